@@ -2,6 +2,7 @@ package ames
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha512"
 	"encoding/json"
 	"errors"
@@ -16,17 +17,24 @@ import (
 )
 
 const fragTag = 0 // 0 is frag, 1 is ack
+const ackTag = 1
 
-var zodAddr = "zod.urbit.org:13337"
 var ethAddr = "0x223c067f8cf28ae173ee5cafea60ca44c335fecb"
 var apiAddr = "http://eth-mainnet.urbit.org:8545"
 var ethMethod = "0x63fa9a87" // "points"
 
+var ErrChecksum = errors.New("error: checksum does not match")
+
+// ErrNotFound is returned by Lookup for a ship with no keys on azimuth
+var ErrNotFound = errors.New("ames: ship has no keys on azimuth")
+
 type LookupResponse struct {
 	EncryptionKey     string
 	AuthenticationKey string
 	Sponsor           string
+	HasSponsor        bool
 	Life              int64
+	Rift              int64 // continuity number, bumped on each breach
 }
 
 type ETHResponse struct {
@@ -34,36 +42,68 @@ type ETHResponse struct {
 }
 
 func Lookup(name string) (LookupResponse, error) {
+	return LookupContext(context.Background(), name)
+}
+
+// LookupContext is Lookup bounded by ctx
+func LookupContext(ctx context.Context, name string) (LookupResponse, error) {
 	hex, err := noun.Patp2hex(name)
 	if err != nil {
 		return LookupResponse{}, err
 	}
 
-	res, err := makeEthRequest(hex)
+	res, err := makeEthRequest(ctx, hex)
 	if err != nil {
 		return LookupResponse{}, err
 	}
-	if len(res) < 64 {
+	return parsePoint(res)
+}
+
+// parsePoint reads the words of an azimuth points() call
+func parsePoint(res string) (LookupResponse, error) {
+	if len(res) < 2+64*10 {
 		return LookupResponse{}, errors.New("Invalid ETH response")
 	}
 	// remove 0x prefix then split by 64 chars
 	parts := noun.Chunks(res[2:], 64)
 
-	life, err := strconv.Atoi(parts[8])
+	life, err := strconv.ParseInt(parts[8], 16, 64)
+	if err != nil {
+		return LookupResponse{}, err
+	}
+	rift, err := strconv.ParseInt(parts[9], 16, 64)
+	if err != nil {
+		return LookupResponse{}, err
+	}
+	// azimuth answers for every point, those never keyed are all zeros
+	if life == 0 {
+		return LookupResponse{}, ErrNotFound
+	}
 	resp := LookupResponse{
 		EncryptionKey:     parts[0],
 		AuthenticationKey: parts[1],
 		Sponsor:           parts[5],
-		Life:              int64(life),
+		HasSponsor:        strings.TrimLeft(parts[2], "0") == "1",
+		Life:              life,
+		Rift:              rift,
 	}
-
-	return resp, err
+	return resp, nil
 }
 
 func ConstructPoke(path []string, mark string, data noun.Noun) noun.Noun {
 	return noun.MakeNoun([]interface{}{"g", path, 0, "m", mark, data})
 }
 
+// ConstructWatch subscribes to watchPath on the agent at path
+func ConstructWatch(path []string, watchPath []string) noun.Noun {
+	return noun.MakeNoun([]interface{}{"g", path, 0, "s", watchPath})
+}
+
+// ConstructLeave ends the subscription on the flow
+func ConstructLeave(path []string) noun.Noun {
+	return noun.MakeNoun([]interface{}{"g", path, 0, "u", 0})
+}
+
 func DestructPoke(n noun.Noun) ([]string, string, noun.Noun, error) {
 	path, err := destructPath(Head(Tail(n)))
 	if err != nil {
@@ -141,15 +181,30 @@ func JoinMessage(n []noun.Noun) (noun.Noun, error) {
 		msg = CatLen(msg, frag.Value, uint(k<<13))
 	}
 
-	full := Cue(msg)
-
-	return full, nil
+	// the fragments are the peer's, so cue them with bounds
+	return SafeCue(msg)
 }
 
 func FragmentToShutPacket(frag noun.Noun, bone int) noun.Noun {
 	return noun.MakeNoun([]interface{}{bone, noun.Head(frag), fragTag, noun.Tail(frag)})
 }
 
+// FragmentAckToShutPacket acks a single fragment of message num
+func FragmentAckToShutPacket(bone, num, fun int) noun.Noun {
+	return noun.MakeNoun([]interface{}{bone, num, ackTag, 0, fun})
+}
+
+// MessageAckToShutPacket acks (ok) or nacks a whole message
+func MessageAckToShutPacket(bone, num int, ok bool) noun.Noun {
+	// loobean, 0 is yes
+	flag := 0
+	if !ok {
+		flag = 1
+	}
+	// [%| ok lag], lag is always zero
+	return noun.MakeNoun([]interface{}{bone, num, ackTag, 1, flag, 0})
+}
+
 // ShutPacketToMeat takes a raw encrypted packet
 // returns the encrypted packet content, bone, packet num, and packet type
 func ShutPacketToMeat(n Noun) (int, int, bool, Noun, error) {
@@ -191,7 +246,9 @@ func EncodeShutPacket(pkt noun.Noun, symKey []byte, from *big.Int, to *big.Int,
 		return noun.MakeNoun(0), err
 	}
 
-	rLen := noun.B(noun.ByteLen(cypherText))
+	// the cyphertext is as long as the jam, the atom may have lost
+	// trailing zero bytes
+	rLen := noun.B(noun.ByteLen(jPkt))
 	iv2 := make([]byte, 16)
 	copy(iv2, ivs[:])
 
@@ -213,29 +270,41 @@ func EncodePacket(encoded Noun) []byte {
 
 	content, _ := AssertAtom(Tail(Tail(Tail(Tail(encoded)))))
 
+	body, senderRank, receiverRank := encodeBody(sender, receiver, senderTick.Value, receiverTick.Value, content.Value)
+	return encodeHeader(body, true, true, senderRank, receiverRank)
+}
+
+// encodeBody puts the ticks and ship names in front of the content
+func encodeBody(sender, receiver Atom, senderTick, receiverTick, content *big.Int) (*big.Int, uint32, uint32) {
 	senderSize, senderRank := EncodeShipMetadata(sender)
 	receiverSize, receiverRank := EncodeShipMetadata(receiver)
 
 	body := B(0).Xor(
-		senderTick.Value,
+		senderTick,
 		B(0).Xor(
-			B(0).Lsh(receiverTick.Value, 4),
+			B(0).Lsh(receiverTick, 4),
 			B(0).Xor(
 				B(0).Lsh(sender.Value, 8),
 				B(0).Xor(
 					B(0).Lsh(receiver.Value, uint(8*(senderSize+1))),
-					B(0).Lsh(content.Value, uint(8*(senderSize+receiverSize+1))),
+					B(0).Lsh(content, uint(8*(senderSize+receiverSize+1))),
 				),
 			),
 		),
 	)
+	return body, senderRank, receiverRank
+}
 
+// encodeHeader prefixes the body with the packet header.
+// request and isAmes are loobeans on the wire, 0 is yes
+func encodeHeader(body *big.Int, request, isAmes bool, senderRank, receiverRank uint32) []byte {
 	checksum := Mug(MakeNoun(body)) & 0xfffff
 
 	// xor leftshift magic
 	header :=
 		(0 << 0) ^ // padding
-			(0 << 3) ^ // is-ames
+			(loobean(request) << 2) ^
+			(loobean(isAmes) << 3) ^
 			(0 << 4) ^ // version of zero
 			(senderRank << 7) ^
 			(receiverRank << 9) ^
@@ -246,6 +315,13 @@ func EncodePacket(encoded Noun) []byte {
 	return BigToLittle(b2)
 }
 
+func loobean(b bool) uint32 {
+	if b {
+		return 0
+	}
+	return 1
+}
+
 // EncodeShipMetadata returns size, rank of given name
 func EncodeShipMetadata(name Noun) (uint32, uint32) {
 	a, err := AssertAtom(name)
@@ -307,12 +383,32 @@ func ParseSeed(seed *big.Int) (*big.Int, *big.Int, [32]byte, error) {
 	return name, life, privKey, nil
 }
 
-func hexSeedToBig(seed string) (*big.Int, bool) {
-	b := B(0)
-	if strings.Contains(seed, ".") {
-		return b.SetString(strings.ReplaceAll(seed, ".", ""), 0)
+// parseAuthSeed returns the signing half of the key in a seed
+func parseAuthSeed(seed *big.Int) [32]byte {
+	key, _ := AssertAtom(Head(Tail(Tail(Cue(seed)))))
+	var authSeed [32]byte
+	if key.Value == nil {
+		return authSeed
 	}
-	return b.SetString(seed, 10)
+	copy(authSeed[:], BigToLittle(noun.Cut(8, 256, key.Value)))
+	return authSeed
+}
+
+// ParseKey reads a key or seed in any encoding urbit prints it in:
+// @uw (0w...), @ux (0x...) or @ud, with or without dots
+func ParseKey(key string) (*big.Int, error) {
+	key = strings.Join(strings.Fields(key), "")
+	switch {
+	case strings.HasPrefix(key, "0w"):
+		return noun.Uw2bn(key)
+	case strings.HasPrefix(key, "0x"):
+		return noun.Ux2bn(key)
+	}
+	b, ok := B(0).SetString(strings.ReplaceAll(key, ".", ""), 10)
+	if !ok {
+		return nil, errors.New("Invalid seed value or encoding provided")
+	}
+	return b, nil
 }
 
 func DecodeShutPacket(content *big.Int, symKey []byte, from, to, fromTick, toTick *big.Int, fromLife, toLife int64) (noun.Noun, error) {
@@ -335,42 +431,74 @@ func DecodeShutPacket(content *big.Int, symKey []byte, from, to, fromTick, toTic
 
 	kHash := sha512.Sum512(symKey)
 
-	decoded, err := urcrypt.UrcryptAESSivcDe(cypherText, aVec, kHash, ivs)
+	decoded, err := urcrypt.UrcryptAESSivcDeLen(cypherText, int(len1), aVec, kHash, ivs)
 	if err != nil {
 		return MakeNoun(0), err
 	}
 
-	return noun.Cue(decoded), err
+	return noun.SafeCue(decoded)
 }
 
 func DecodePacket(pkt []byte) (*big.Int, *big.Int, *big.Int, *big.Int, *big.Int, error) {
+	header, body, err := decodeHeader(pkt)
+	if err != nil {
+		return B(0), B(0), B(0), B(0), B(0), err
+	}
+	if !header.isAmes || header.version != 0 {
+		return B(0), B(0), B(0), B(0), B(0), errors.New("error: version invalid")
+	}
+	senderValue, receiverValue, senderTick, receiverTick, content := decodeBody(header, body)
+	return senderValue, receiverValue, senderTick, receiverTick, content, nil
+}
+
+type packetHeader struct {
+	request      bool
+	isAmes       bool
+	version      byte
+	senderRank   byte
+	receiverRank byte
+	relayed      bool
+	origin       *big.Int // lane of the sender as seen by the relay
+}
+
+// decodeHeader parses the header and checks the body checksum
+func decodeHeader(pkt []byte) (packetHeader, *big.Int, error) {
+	if len(pkt) < 4 {
+		return packetHeader{}, B(0), errors.New("error: packet too short")
+	}
 	header := pkt[:4]
-	body := pkt[4:]
+	body := make([]byte, len(pkt)-4)
+	copy(body, pkt[4:])
 
 	var checksum uint32
 
-	isAmes := (header[0] >> 3 & 0b1)
-	version := header[0] >> 4 & 0b111
-	if isAmes != 0 || version != 0 {
-		return B(0), B(0), B(0), B(0), B(0), errors.New("error: version invalid")
+	h := packetHeader{
+		request: (header[0] >> 2 & 0b1) == 0,
+		isAmes:  (header[0] >> 3 & 0b1) == 0,
+		version: header[0] >> 4 & 0b111,
 	}
-	senderRank := (header[0] >> 7 & 0b1) ^ ((header[1] >> 0 & 0b1) << 1)
-	receiverRank := header[1] >> 1 & 0b11
+	h.senderRank = (header[0] >> 7 & 0b1) ^ ((header[1] >> 0 & 0b1) << 1)
+	h.receiverRank = header[1] >> 1 & 0b11
 	checksum = (uint32(header[1]) >> 3 & 0b11111) ^ (uint32(header[2]) << 5) ^ ((uint32(header[3]) >> 0 & 0b1111111) << 13)
-	isRelay := (header[3] >> 7 & 0b1) == 0
+	h.relayed = (header[3] >> 7 & 0b1) == 0
 
 	lBody := LittleToBig(body)
-	if isRelay {
+	if h.relayed {
+		h.origin = noun.Cut(0, 48, lBody)
 		lBody.Rsh(lBody, uint(48))
 	}
 	nBody := noun.MakeNoun(lBody)
 
 	if Mug(nBody)&0xfffff != checksum {
-		return B(0), B(0), B(0), B(0), B(0), errors.New("error: checksum does not match")
+		return h, B(0), ErrChecksum
 	}
+	return h, lBody, nil
+}
 
-	senderSize := DecodeShipMetadata(senderRank)
-	receiverSize := DecodeShipMetadata(receiverRank)
+// decodeBody returns sender, receiver, sender tick, receiver tick and content
+func decodeBody(h packetHeader, lBody *big.Int) (*big.Int, *big.Int, *big.Int, *big.Int, *big.Int) {
+	senderSize := DecodeShipMetadata(h.senderRank)
+	receiverSize := DecodeShipMetadata(h.receiverRank)
 
 	senderTick := noun.Cut(0, 4, lBody)
 	receiverTick := noun.Cut(4, 4, lBody)
@@ -380,16 +508,16 @@ func DecodePacket(pkt []byte) (*big.Int, *big.Int, *big.Int, *big.Int, *big.Int,
 	content := B(0)
 	content.Rsh(lBody, uint(8+senderSize+receiverSize))
 
-	return senderValue, receiverValue, senderTick, receiverTick, content, nil
+	return senderValue, receiverValue, senderTick, receiverTick, content
 }
 
-func makeEthRequest(nameHex string) (string, error) {
+func makeEthRequest(ctx context.Context, nameHex string) (string, error) {
 	padName := padLeft(nameHex, 64, "0")
 	str := `{"jsonrpc":"2.0","id":"0","method":"eth_call","params":[{"to": "` + ethAddr + `", "data": "` + ethMethod + padName + `"}, "latest"]}`
 
 	body := bytes.NewReader([]byte(str))
 
-	req, err := http.NewRequest("POST", apiAddr, body)
+	req, err := http.NewRequestWithContext(ctx, "POST", apiAddr, body)
 	if err != nil {
 		return "", err
 	}
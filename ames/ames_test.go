@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stevelacy/go-urbit/noun"
 )
@@ -59,6 +60,36 @@ func TestEncodeShutPacket(t *testing.T) {
 	}
 }
 
+// TestShutPacketZeroTail round trips packets whose cyphertext ends in
+// zero bytes. The length sent is the jam's, not the cyphertext atom's
+func TestShutPacketZeroTail(t *testing.T) {
+	from, to := noun.B(0x10100), noun.B(0x10200)
+	short := 0
+	for i := 0; i < 2048 && short < 2; i++ {
+		pkt := noun.MakeNoun([]interface{}{1, i, 0, 1, 0, i})
+		r1, err := EncodeShutPacket(pkt, []byte{31}, from, to, 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := noun.AssertAtom(noun.Tail(noun.Tail(noun.Tail(noun.Tail(r1)))))
+		length := noun.Cut(128, 16, content.Value).Int64()
+		if length != int64(noun.ByteLen(noun.Jam(pkt))) {
+			t.Errorf("expected %v got %v", noun.ByteLen(noun.Jam(pkt)), length)
+		}
+		if content.Value.BitLen() > 144+8*int(length-1) {
+			continue
+		}
+		short++
+		r2, err := DecodeShutPacket(content.Value, []byte{31}, from, to, noun.B(1), noun.B(2), 1, 2)
+		if err != nil || r2.String() != pkt.String() {
+			t.Errorf("expected %v got %v %v", pkt, r2, err)
+		}
+	}
+	if short == 0 {
+		t.Errorf("expected a cyphertext with a zero last byte")
+	}
+}
+
 func TestEncodePacket(t *testing.T) {
 	c1 := []byte{128, 28, 112, 182, 33, 0, 1, 1, 0, 0, 1, 1, 0, 113, 126, 0, 0, 251, 177, 66, 74, 134, 147, 242, 188, 119, 57, 37, 27, 132, 153, 69, 253, 34, 0, 174, 98, 110, 181, 25, 144, 121, 192, 44, 232, 136, 22, 223, 146, 232, 23, 9, 200, 94, 235, 235, 169, 110, 64, 44, 233, 30, 17, 20, 94, 212, 254, 76, 106}
 	n1 := ConstructPoke([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("ping"))
@@ -94,6 +125,25 @@ func TestDestructPath(t *testing.T) {
 	fmt.Println(n, err)
 } */
 
+// TestJoinMessageMalformed joins a fragment that sends an unbounded cue
+// into a loop
+func TestJoinMessageMalformed(t *testing.T) {
+	frag := noun.MakeNoun([]interface{}{1, 0, noun.B(0x89bc7f01)})
+	done := make(chan error, 1)
+	go func() {
+		_, err := JoinMessage([]noun.Noun{frag})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("expected an error got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("JoinMessage did not return")
+	}
+}
+
 func TestShutPacketToFragment(t *testing.T) {
 	num := 11
 	bone := 9
@@ -160,3 +210,16 @@ func ExampleLookup() {
 	}
 	fmt.Println(res.EncryptionKey)
 }
+
+func TestConstructWatch(t *testing.T) {
+	c1 := "[103 [25959 1685024616 0] 0 115 29793 0]"
+	r1 := ConstructWatch([]string{"ge", "hood"}, []string{"at"})
+	if r1.String() != c1 {
+		t.Errorf("expected %s got %s", c1, r1)
+	}
+	c2 := "[103 [25959 1685024616 0] 0 117 0]"
+	r2 := ConstructLeave([]string{"ge", "hood"})
+	if r2.String() != c2 {
+		t.Errorf("expected %s got %s", c2, r2)
+	}
+}
@@ -0,0 +1,65 @@
+package amestest
+
+import (
+	"sync"
+
+	"github.com/stevelacy/go-urbit/ames"
+	"github.com/stevelacy/go-urbit/noun"
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+// Azimuth is a fake of the keys on azimuth, shared by the fake ships
+// and the client under test through Options.Lookup
+type Azimuth struct {
+	mut sync.Mutex
+	ids map[string]*ames.Identity
+}
+
+func NewAzimuth() *Azimuth {
+	return &Azimuth{ids: make(map[string]*ames.Identity)}
+}
+
+// Generate makes keys for ship at life 1 and publishes them
+func (z *Azimuth) Generate(ship string) (*ames.Identity, error) {
+	p, err := noun.Patp2bn(ship)
+	if err != nil {
+		return nil, err
+	}
+	id, err := ames.GenerateIdentity(p, 1)
+	if err != nil {
+		return nil, err
+	}
+	z.Add(id)
+	return id, nil
+}
+
+// Add publishes the keys of id, replacing any it had before
+func (z *Azimuth) Add(id *ames.Identity) {
+	name, _ := noun.BN2patp(id.Ship)
+	z.mut.Lock()
+	defer z.mut.Unlock()
+	z.ids[name] = id
+}
+
+// Lookup is Options.Lookup. Points we have no keys for are unspawned,
+// and like azimuth give ames.ErrNotFound
+func (z *Azimuth) Lookup(name string) (ames.LookupResponse, error) {
+	id, ok := z.identity(name)
+	if !ok {
+		return ames.LookupResponse{}, ames.ErrNotFound
+	}
+	enc := urcrypt.UrcryptEdPuck(id.CryptKey)
+	auth := urcrypt.UrcryptEdPuck(id.AuthKey)
+	return ames.LookupResponse{
+		EncryptionKey:     noun.LittleToBig(enc[:]).Text(16),
+		AuthenticationKey: noun.LittleToBig(auth[:]).Text(16),
+		Life:              id.Life,
+	}, nil
+}
+
+func (z *Azimuth) identity(name string) (*ames.Identity, bool) {
+	z.mut.Lock()
+	defer z.mut.Unlock()
+	id, ok := z.ids[name]
+	return id, ok
+}
@@ -0,0 +1,476 @@
+// Package amestest runs fake ships on localhost UDP so clients can be
+// tested without a real ship or the internet.
+//
+// A fake ship speaks just enough ames to hear pleas, ack or nack them
+// by scripted rules, give facts to subscribers and, as a galaxy, relay
+// packets to the ships behind it. Point a client at one with
+// Options.Lookup and Options.Galaxies, with fakes or keys for every
+// ship it looks up, its sponsor included:
+//
+//	z := amestest.NewAzimuth()
+//	zod, _ := amestest.NewShip(z, "~zod")
+//	binzod, _ := amestest.NewShip(z, "~binzod")
+//	zod.Route(binzod)
+//	id, _ := z.Generate("~donryg-ribwyt")
+//	a, _ := ames.NewAmesWithIdentity(id, onPacket, ames.Options{
+//		Lookup:   z.Lookup,
+//		Galaxies: map[string]string{"~zod": zod.Addr().String()},
+//	})
+package amestest
+
+import (
+	"errors"
+	"math/big"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stevelacy/go-urbit/ames"
+	"github.com/stevelacy/go-urbit/noun"
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+// resendInterval is how often a fake ship resends unacked messages
+var resendInterval = 200 * time.Millisecond
+
+// Rule decides what a fake ship does with a plea. The first rule that
+// matches is used, a plea matching none is acked
+type Rule struct {
+	App  string // empty matches any app
+	Mark string // empty matches any poke mark, and watches
+	// Nack nacks the plea with this tang instead of acking it
+	Nack []string
+	// Drop neither acks nor nacks, as a ship that has gone away
+	Drop bool
+	// Facts are given to a watch straight after its ack
+	Facts []ames.Fact
+}
+
+// Heard is a plea a fake ship was sent
+type Heard struct {
+	From string
+	Plea ames.Plea
+}
+
+// Ship is a fake ship listening on localhost
+type Ship struct {
+	Identity *ames.Identity
+	name     string
+	azimuth  *Azimuth
+	conn     *net.UDPConn
+	mut      sync.Mutex
+	rules    []Rule
+	heard    []Heard
+	peers    map[string]*peer
+	routes   map[string]*net.UDPAddr // ships a galaxy relays to
+	quit     chan struct{}
+	wg       sync.WaitGroup
+}
+
+type peer struct {
+	ship   *big.Int
+	life   int64
+	symKey []byte
+	lane   *net.UDPAddr
+	flows  map[int]*flow // by our bone
+}
+
+// flow is one bone with a peer. Like ames, a flow the peer sends on
+// with bone b is our bone b^1
+type flow struct {
+	frags   map[int]map[int]noun.Noun // num > fun > meat
+	acked   map[int]bool              // delivered message nums, false if nacked
+	num     int                       // our next message num
+	pending map[int][][]byte          // our unacked messages
+	watch   *ames.Watch               // the subscription on this flow
+}
+
+// NewShip generates keys for ship, publishes them on z and listens on
+// a random localhost port
+func NewShip(z *Azimuth, ship string) (*Ship, error) {
+	id, err := z.Generate(ship)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	s := &Ship{
+		Identity: id,
+		name:     ship,
+		azimuth:  z,
+		conn:     conn,
+		peers:    make(map[string]*peer),
+		routes:   make(map[string]*net.UDPAddr),
+		quit:     make(chan struct{}),
+	}
+	s.wg.Add(2)
+	go s.listen()
+	go s.resend()
+	return s, nil
+}
+
+// Name is the ship's @p
+func (s *Ship) Name() string {
+	return s.name
+}
+
+// Addr is where the ship listens, to pin in Options.Galaxies
+func (s *Ship) Addr() *net.UDPAddr {
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Close stops the ship
+func (s *Ship) Close() error {
+	close(s.quit)
+	err := s.conn.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Rule adds a rule after those already added
+func (s *Ship) Rule(r Rule) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.rules = append(s.rules, r)
+}
+
+// Route has a galaxy relay packets for ship to another fake ship
+func (s *Ship) Route(to *Ship) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.routes[to.name] = to.Addr()
+}
+
+// Heard returns the pleas the ship has been sent, oldest first
+func (s *Ship) Heard() []Heard {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]Heard{}, s.heard...)
+}
+
+// Give sends a fact to every subscriber to app on path, returning how
+// many there were
+func (s *Ship) Give(app string, path []string, f ames.Fact) int {
+	return s.boon(app, path, f)
+}
+
+// Kick ends every subscription to app on path
+func (s *Ship) Kick(app string, path []string) int {
+	return s.boon(app, path, ames.Kick{})
+}
+
+func (s *Ship) boon(app string, path []string, b ames.Boon) int {
+	msg, err := ames.EncodeBoon(b)
+	if err != nil {
+		return 0
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	n := 0
+	for _, p := range s.peers {
+		for bone, f := range p.flows {
+			if f.watch == nil || f.watch.App != app || !samePath(f.watch.Path, path) {
+				continue
+			}
+			if _, ok := b.(ames.Kick); ok {
+				f.watch = nil
+			}
+			s.sendMessage(p, bone, msg)
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Ship) listen() {
+	defer s.wg.Done()
+	buf := make([]byte, 8192)
+	for {
+		n, src, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		pkt := append([]byte{}, buf[:n]...)
+		s.hear(pkt, src)
+	}
+}
+
+// resend sends our unacked messages again, there is no congestion
+// control on localhost
+func (s *Ship) resend() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(resendInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quit:
+			return
+		case <-ticker.C:
+		}
+		s.mut.Lock()
+		for _, p := range s.peers {
+			for _, f := range p.flows {
+				for _, pkts := range f.pending {
+					for _, pkt := range pkts {
+						s.conn.WriteToUDP(pkt, p.lane)
+					}
+				}
+			}
+		}
+		s.mut.Unlock()
+	}
+}
+
+func (s *Ship) hear(pkt []byte, src *net.UDPAddr) {
+	from, to, fromTick, toTick, content, err := ames.DecodePacket(pkt)
+	if err != nil {
+		return
+	}
+	lane := src
+	if origin, ok := ames.PacketOrigin(pkt); ok {
+		lane = origin
+	}
+
+	if to.Cmp(s.Identity.Ship) != 0 {
+		s.relay(pkt, to, src)
+		return
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	p, err := s.peer(from)
+	if err != nil {
+		return
+	}
+	p.lane = lane
+	pat, err := ames.DecodeShutPacket(content, p.symKey, from, to, fromTick, toTick, p.life, s.Identity.Life)
+	if err != nil {
+		return
+	}
+	bone, num, isFrag, meat, err := ames.ShutPacketToMeat(pat)
+	if err != nil {
+		return
+	}
+	f := p.flow(bone ^ 1)
+	if !isFrag {
+		// [%| ok lag] acks a whole message, [%& fun] a fragment
+		if final, _ := noun.AssertAtom(noun.Head(meat)); final.Value.Sign() != 0 {
+			delete(f.pending, num)
+		}
+		return
+	}
+	s.hearFragment(p, bone^1, f, num, meat)
+}
+
+// relay forwards a packet for another ship as a galaxy does, adding
+// the lane we heard it from so the receiver can answer directly
+func (s *Ship) relay(pkt []byte, to *big.Int, src *net.UDPAddr) {
+	name, err := noun.BN2patp(to)
+	if err != nil {
+		return
+	}
+	s.mut.Lock()
+	dst, ok := s.routes[name]
+	if p, known := s.peers[name]; !ok && known {
+		dst, ok = p.lane, p.lane != nil
+	}
+	s.mut.Unlock()
+	if !ok {
+		return
+	}
+	out, err := ames.RelayPacket(pkt, src)
+	if err != nil {
+		return
+	}
+	s.conn.WriteToUDP(out, dst)
+}
+
+// peer must be called with mut held
+func (s *Ship) peer(ship *big.Int) (*peer, error) {
+	name, err := noun.BN2patp(ship)
+	if err != nil {
+		return nil, err
+	}
+	if p, ok := s.peers[name]; ok {
+		return p, nil
+	}
+	id, ok := s.azimuth.identity(name)
+	if !ok {
+		return nil, errors.New("amestest: no keys for " + name)
+	}
+	p := &peer{
+		ship:   ship,
+		life:   id.Life,
+		symKey: urcrypt.UrcryptEdShar(urcrypt.UrcryptEdPuck(id.CryptKey), s.Identity.CryptKey),
+		flows:  make(map[int]*flow),
+	}
+	s.peers[name] = p
+	return p, nil
+}
+
+func (p *peer) flow(bone int) *flow {
+	f, ok := p.flows[bone]
+	if !ok {
+		f = &flow{
+			frags:   make(map[int]map[int]noun.Noun),
+			acked:   make(map[int]bool),
+			num:     1,
+			pending: make(map[int][][]byte),
+		}
+		p.flows[bone] = f
+	}
+	return f
+}
+
+func (s *Ship) hearFragment(p *peer, bone int, f *flow, num int, meat noun.Noun) {
+	// message 0 counts as delivered before anything is heard, it is
+	// only sent as a keepalive probe
+	if num == 0 {
+		s.send(p, ames.MessageAckToShutPacket(bone, num, true))
+		return
+	}
+	// a duplicate of a delivered message is acked again
+	if ok, done := f.acked[num]; done {
+		s.send(p, ames.MessageAckToShutPacket(bone, num, ok))
+		return
+	}
+	total, err1 := noun.AssertAtom(noun.Head(meat))
+	fun, err2 := noun.AssertAtom(noun.Head(noun.Tail(meat)))
+	if err1 != nil || err2 != nil || fun.Value.Cmp(total.Value) >= 0 {
+		return
+	}
+	if f.frags[num] == nil {
+		f.frags[num] = make(map[int]noun.Noun)
+	}
+	f.frags[num][int(fun.Value.Int64())] = meat
+	if len(f.frags[num]) < int(total.Value.Int64()) {
+		s.send(p, ames.FragmentAckToShutPacket(bone, num, int(fun.Value.Int64())))
+		return
+	}
+
+	funs := []int{}
+	for fun := range f.frags[num] {
+		funs = append(funs, fun)
+	}
+	sort.Ints(funs)
+	parts := []noun.Noun{}
+	for _, fun := range funs {
+		parts = append(parts, f.frags[num][fun])
+	}
+	delete(f.frags, num)
+	msg, err := ames.JoinMessage(parts)
+	if err != nil {
+		return
+	}
+	plea, err := ames.DecodePlea(msg)
+	if err != nil {
+		// boons and naxplanations on flows we opened aren't looked at
+		f.acked[num] = true
+		s.send(p, ames.MessageAckToShutPacket(bone, num, true))
+		return
+	}
+	s.hearPlea(p, bone, f, num, plea)
+}
+
+func (s *Ship) hearPlea(p *peer, bone int, f *flow, num int, plea ames.Plea) {
+	name, _ := noun.BN2patp(p.ship)
+	s.heard = append(s.heard, Heard{From: name, Plea: plea})
+	r := s.rule(plea)
+	if r.Drop {
+		return
+	}
+	if r.Nack != nil {
+		// the naxplanation goes out on our bone mixed with 2, before
+		// the nack
+		f.acked[num] = false
+		s.sendMessage(p, bone^2, ames.EncodeNaxplanation(num, &ames.NackError{Tag: "amestest", Tang: r.Nack}))
+		s.send(p, ames.MessageAckToShutPacket(bone, num, false))
+		return
+	}
+	f.acked[num] = true
+	s.send(p, ames.MessageAckToShutPacket(bone, num, true))
+
+	switch t := plea.(type) {
+	case ames.Watch:
+		f.watch = &t
+		for _, fact := range r.Facts {
+			msg, err := ames.EncodeBoon(fact)
+			if err == nil {
+				s.sendMessage(p, bone, msg)
+			}
+		}
+	case ames.Leave:
+		f.watch = nil
+	}
+}
+
+// rule must be called with mut held
+func (s *Ship) rule(plea ames.Plea) Rule {
+	for _, r := range s.rules {
+		switch t := plea.(type) {
+		case ames.Poke:
+			if (r.App == "" || r.App == t.App) && (r.Mark == "" || r.Mark == t.Mark) {
+				return r
+			}
+		case ames.Watch:
+			if (r.App == "" || r.App == t.App) && r.Mark == "" {
+				return r
+			}
+		}
+	}
+	return Rule{}
+}
+
+// sendMessage sends a message on our bone, resending until it is acked.
+// It must be called with mut held
+func (s *Ship) sendMessage(p *peer, bone int, msg noun.Noun) {
+	f := p.flow(bone)
+	num := f.num
+	f.num++
+	var pkts [][]byte
+	for _, frag := range ames.SplitMessage(num, msg) {
+		pkt, err := s.encode(p, ames.FragmentToShutPacket(frag, bone))
+		if err != nil {
+			return
+		}
+		pkts = append(pkts, pkt)
+	}
+	f.pending[num] = pkts
+	for _, pkt := range pkts {
+		s.conn.WriteToUDP(pkt, p.lane)
+	}
+}
+
+func (s *Ship) send(p *peer, pat noun.Noun) {
+	pkt, err := s.encode(p, pat)
+	if err != nil {
+		return
+	}
+	s.conn.WriteToUDP(pkt, p.lane)
+}
+
+func (s *Ship) encode(p *peer, pat noun.Noun) ([]byte, error) {
+	pack, err := ames.EncodeShutPacket(pat, p.symKey, s.Identity.Ship, p.ship, s.Identity.Life, p.life)
+	if err != nil {
+		return nil, err
+	}
+	return ames.EncodePacket(pack), nil
+}
+
+func samePath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
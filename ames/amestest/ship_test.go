@@ -0,0 +1,118 @@
+package amestest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/ames"
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+func TestShip(t *testing.T) {
+	z := NewAzimuth()
+	zod, err := NewShip(z, "~zod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zod.Close()
+	// the client's sponsor, which it looks up and connects to on boot
+	binzod, err := NewShip(z, "~binzod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer binzod.Close()
+	zod.Route(binzod)
+	// a planet under ~marzod, reached through ~zod
+	target, _ := noun.BN2patp(noun.B(0x10100))
+	s, err := NewShip(z, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	zod.Route(s)
+	s.Rule(Rule{App: "hood", Mark: "helm-nack", Nack: []string{"no thanks"}})
+	s.Rule(Rule{App: "hood", Mark: "helm-drop", Drop: true})
+	s.Rule(Rule{App: "chat", Facts: []ames.Fact{{Mark: "json", Data: noun.MakeNoun("hello")}}})
+
+	client, _ := noun.BN2patp(noun.B(0x10200))
+	id, err := z.Generate(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := ames.NewAmesWithIdentity(id, nil, ames.Options{
+		Lookup:    z.Lookup,
+		Galaxies:  map[string]string{"~zod": zod.Addr().String()},
+		Keepalive: ames.Keepalive{Disabled: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close(context.Background())
+
+	c, err := a.Connect(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Connect("~sampel-palnet"); !errors.Is(err, ames.ErrNotFound) {
+		t.Errorf("expected %v got %v", ames.ErrNotFound, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = c.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, direct := c.Peer.Lane(); !direct {
+		t.Errorf("expected the target to answer directly")
+	}
+	heard := s.Heard()
+	if len(heard) != 1 || heard[0].From != client || heard[0].Plea.(ames.Poke).Mark != "helm-hi" {
+		t.Errorf("expected a helm-hi from %v got %v", client, heard)
+	}
+
+	err = c.Poke(ctx, []string{"ge", "hood"}, "helm-nack", noun.MakeNoun(0))
+	var nerr *ames.NackError
+	if !errors.As(err, &nerr) || len(nerr.Tang) != 1 || nerr.Tang[0] != "no thanks" {
+		t.Errorf("expected a nack got %v", err)
+	}
+
+	short, cancelShort := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancelShort()
+	err = c.Poke(short, []string{"ge", "hood"}, "helm-drop", noun.MakeNoun(0))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected %v got %v", context.DeadlineExceeded, err)
+	}
+
+	sc, err := a.Connect(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := sc.Subscribe("chat", []string{"updates"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func() ames.Fact {
+		select {
+		case f := <-sub.Facts:
+			return f
+		case <-ctx.Done():
+			t.Fatal("expected a fact")
+		}
+		return ames.Fact{}
+	}
+	if f := next(); f.Mark != "json" || f.Data.String() != noun.MakeNoun("hello").String() {
+		t.Errorf("expected %v got %v", "hello", f)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for s.Give("chat", []string{"updates"}, ames.Fact{Mark: "json", Data: noun.MakeNoun("again")}) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if f := next(); f.Data.String() != noun.MakeNoun("again").String() {
+		t.Errorf("expected %v got %v", "again", f)
+	}
+	if n := s.Kick("chat", []string{"updates"}); n != 1 {
+		t.Errorf("expected %v got %v", 1, n)
+	}
+}
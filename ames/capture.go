@@ -0,0 +1,153 @@
+package ames
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Capture is one datagram we sent or heard
+type Capture struct {
+	Time time.Time
+	Out  bool         // sent by us rather than heard
+	Lane *net.UDPAddr // where it went to or came from
+	Data []byte
+}
+
+// String is the capture's line in a capture file, as
+// <time> <in|out> <lane> <hex>
+func (c Capture) String() string {
+	dir := "in"
+	if c.Out {
+		dir = "out"
+	}
+	lane := "-"
+	if c.Lane != nil {
+		lane = c.Lane.String()
+	}
+	return c.Time.UTC().Format(time.RFC3339Nano) + " " + dir + " " + lane + " " + hex.EncodeToString(c.Data)
+}
+
+// ParseCapture is the reverse of Capture.String
+func ParseCapture(line string) (Capture, error) {
+	parts := strings.Fields(line)
+	if len(parts) != 4 {
+		return Capture{}, errors.New("capture: expected time, direction, lane and data")
+	}
+	t, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return Capture{}, fmt.Errorf("capture: %w", err)
+	}
+	c := Capture{Time: t}
+	switch parts[1] {
+	case "in":
+	case "out":
+		c.Out = true
+	default:
+		return Capture{}, errors.New("capture: direction must be in or out")
+	}
+	if parts[2] != "-" {
+		c.Lane, err = net.ResolveUDPAddr("udp", parts[2])
+		if err != nil {
+			return Capture{}, fmt.Errorf("capture: %w", err)
+		}
+	}
+	c.Data, err = hex.DecodeString(parts[3])
+	if err != nil {
+		return Capture{}, fmt.Errorf("capture: %w", err)
+	}
+	return c, nil
+}
+
+// Recorder is given every datagram we send or hear, see
+// Options.Recorder. Record is called from the goroutine doing the
+// sending or reading and shouldn't block
+type Recorder interface {
+	Record(c Capture)
+}
+
+// CaptureWriter records captures to w one per line, for amesdump
+type CaptureWriter struct {
+	mut sync.Mutex
+	w   io.Writer
+	err error
+}
+
+func NewCaptureWriter(w io.Writer) *CaptureWriter {
+	return &CaptureWriter{w: w}
+}
+
+// Record writes the capture, after the first error nothing more is
+// written
+func (w *CaptureWriter) Record(c Capture) {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	if w.err != nil {
+		return
+	}
+	_, w.err = io.WriteString(w.w, c.String()+"\n")
+}
+
+// Err is the first error met writing
+func (w *CaptureWriter) Err() error {
+	w.mut.Lock()
+	defer w.mut.Unlock()
+	return w.err
+}
+
+// CaptureReader reads a capture file written by CaptureWriter
+type CaptureReader struct {
+	s *bufio.Scanner
+}
+
+func NewCaptureReader(r io.Reader) *CaptureReader {
+	s := bufio.NewScanner(r)
+	// a hex encoded datagram is twice its size
+	s.Buffer(make([]byte, 4096), 4*maxPacketSize)
+	return &CaptureReader{s}
+}
+
+// Next returns the next capture, or io.EOF at the end. Blank lines and
+// lines starting with # are skipped
+func (r *CaptureReader) Next() (Capture, error) {
+	for r.s.Scan() {
+		line := strings.TrimSpace(r.s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return ParseCapture(line)
+	}
+	if err := r.s.Err(); err != nil {
+		return Capture{}, err
+	}
+	return Capture{}, io.EOF
+}
+
+// recordTransport passes every datagram through a Recorder
+type recordTransport struct {
+	Transport
+	rec   Recorder
+	clock clock
+}
+
+func (t recordTransport) ReadFrom(b []byte) (int, *net.UDPAddr, error) {
+	n, addr, err := t.Transport.ReadFrom(b)
+	if err == nil {
+		t.rec.Record(Capture{Time: t.clock.Now(), Lane: addr, Data: append([]byte{}, b[:n]...)})
+	}
+	return n, addr, err
+}
+
+func (t recordTransport) WriteTo(b []byte, addr *net.UDPAddr) (int, error) {
+	n, err := t.Transport.WriteTo(b, addr)
+	if err == nil {
+		t.rec.Record(Capture{Time: t.clock.Now(), Out: true, Lane: addr, Data: append([]byte{}, b...)})
+	}
+	return n, err
+}
@@ -0,0 +1,55 @@
+package ames
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCapture(t *testing.T) {
+	c := Capture{
+		Time: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+		Out:  true,
+		Lane: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 13337},
+		Data: []byte{0, 1, 0xff},
+	}
+	var buf bytes.Buffer
+	w := NewCaptureWriter(&buf)
+	w.Record(c)
+	w.Record(Capture{Time: c.Time, Data: []byte{2}})
+	if w.Err() != nil {
+		t.Fatal(w.Err())
+	}
+	expected := "2024-01-02T03:04:05.000000006Z out 10.0.0.1:13337 0001ff\n"
+	if !strings.HasPrefix(buf.String(), expected) {
+		t.Errorf("expected %q got %q", expected, buf.String())
+	}
+
+	r := NewCaptureReader(strings.NewReader("# a comment\n\n" + buf.String()))
+	r1, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r1.Time.Equal(c.Time) || !r1.Out || r1.Lane.String() != c.Lane.String() || !bytes.Equal(r1.Data, c.Data) {
+		t.Errorf("expected %v got %v", c, r1)
+	}
+	r2, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r2.Out || r2.Lane != nil || !bytes.Equal(r2.Data, []byte{2}) {
+		t.Errorf("expected an incoming packet with no lane got %v", r2)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected %v got %v", io.EOF, err)
+	}
+
+	for _, line := range []string{"", "2024-01-02T03:04:05Z sideways - 00", "yesterday in - 00", "2024-01-02T03:04:05Z in - zz"} {
+		if _, err := ParseCapture(line); err == nil {
+			t.Errorf("expected %q to fail", line)
+		}
+	}
+}
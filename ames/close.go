@@ -0,0 +1,145 @@
+package ames
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrClosed = errors.New("ames: closed")
+
+// Close stops every goroutine, closes the socket and releases all
+// peers. With FlushOnClose it first waits for sent messages to be
+// acked, until ctx is done. Pending pokes, scries and subscriptions
+// end with ErrClosed. Close waits for a running plea handler to
+// return, so it mustn't be called from one
+func (a *Ames) Close(ctx context.Context) error {
+	err := ErrClosed
+	a.closeOnce.Do(func() {
+		err = nil
+		if a.opts.FlushOnClose {
+			err = a.Flush(ctx)
+		}
+		if a.quit != nil {
+			a.spawnMut.Lock()
+			close(a.quit)
+			a.spawnMut.Unlock()
+		}
+		if a.conn != nil {
+			cerr := a.conn.Close()
+			if err == nil {
+				err = cerr
+			}
+		}
+		a.wg.Wait()
+		serr := a.save()
+		if err == nil {
+			err = serr
+		}
+		a.release()
+	})
+	return err
+}
+
+// Flush waits until every message we have sent is acked or ctx is done
+func (a *Ames) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(pumpInterval)
+	defer ticker.Stop()
+	for a.pending() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-a.quit:
+			return ErrClosed
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// pending counts the fragments not yet acked across all flows
+func (a *Ames) pending() int {
+	n := 0
+	for _, c := range a.connections() {
+		c.mut.Lock()
+		n += c.pump.Pending()
+		c.mut.Unlock()
+	}
+	return n
+}
+
+// abandon ends the pokes and subscription waiting on the flow with err
+func (c *Connection) abandon(err error) {
+	c.mut.Lock()
+	futures := c.futures
+	c.futures = make(map[int]*PokeFuture)
+	for _, timer := range c.nacks {
+		timer.Stop()
+	}
+	c.nacks = make(map[int]*time.Timer)
+	sub := c.sub
+	c.mut.Unlock()
+	for _, f := range futures {
+		f.err = err
+		close(f.done)
+	}
+
+	if sub != nil {
+		sub.quitOnce.Do(func() { close(sub.quit) })
+		sub.mut.Lock()
+		if !sub.done {
+			sub.end(err)
+		}
+		sub.mut.Unlock()
+	}
+}
+
+// spawn runs f in a goroutine that Close waits for, unless we are
+// closing, and reports whether it did
+func (a *Ames) spawn(f func()) bool {
+	a.spawnMut.Lock()
+	defer a.spawnMut.Unlock()
+	if a.isClosed() {
+		return false
+	}
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		f()
+	}()
+	return true
+}
+
+func (a *Ames) isClosed() bool {
+	select {
+	case <-a.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// release fails everything still waiting on the network and drops
+// the peers
+func (a *Ames) release() {
+	for _, c := range a.connections() {
+		c.abandon(ErrClosed)
+	}
+	a.peerMut.Lock()
+	a.Peers = make(map[string]*Peer)
+	a.peerMut.Unlock()
+
+	a.scryMut.Lock()
+	for _, req := range a.scries {
+		req.mut.Lock()
+		select {
+		case <-req.done:
+		default:
+			req.err = ErrClosed
+			close(req.done)
+		}
+		req.mut.Unlock()
+	}
+	a.scries = nil
+	a.scryMut.Unlock()
+}
@@ -0,0 +1,174 @@
+package ames
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+// TestMain fails the package if any test leaves an Ames running
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if code == 0 {
+		if leaked := leakedGoroutines(); leaked != "" {
+			fmt.Println("leaked goroutines:\n" + leaked)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// leakedGoroutines returns the stacks of our goroutines still running
+// after a grace period for them to exit
+func leakedGoroutines() string {
+	var leaked []string
+	for i := 0; i < 20; i++ {
+		leaked = nil
+		buf := make([]byte, 1<<20)
+		buf = buf[:runtime.Stack(buf, true)]
+		for _, g := range strings.Split(string(buf), "\n\n") {
+			if strings.Contains(g, "go-urbit/ames.(*Ames)") || strings.Contains(g, "go-urbit/ames.(*Subscription)") ||
+				strings.Contains(g, "go-urbit/ames.(*Connection)") {
+				leaked = append(leaked, g)
+			}
+		}
+		if len(leaked) == 0 {
+			return ""
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return strings.Join(leaked, "\n\n")
+}
+
+func TestClose(t *testing.T) {
+	a, err := newAmes(noun.B(0x10100), 1, [32]byte{}, [32]byte{}, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.clock = newFakeClock()
+	peer := &Peer{ship: noun.B(0x100), Connections: make(map[int]*Connection)}
+	a.Peers["~marzod"] = peer
+	c := pokeConnection()
+	c.ames = a
+	c.Peer = peer
+	c.pump = newPump(a.clock)
+	peer.Connections[1] = c
+	f := pokeFuture(c, 1)
+	// a nack still waiting on its naxplanation
+	nacked := pokeFuture(c, 2)
+	c.onPokeAck(2, true)
+	timer := c.nacks[2]
+
+	err = a.Close(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+	if leaked := leakedGoroutines(); leaked != "" {
+		t.Errorf("expected no goroutines got %s", leaked)
+	}
+	if f.Wait(context.Background()) != ErrClosed || nacked.Wait(context.Background()) != ErrClosed {
+		t.Errorf("expected %v got %v %v", ErrClosed, f.Err(), nacked.Err())
+	}
+	if timer.Stop() {
+		t.Errorf("expected the naxplanation timer to be stopped")
+	}
+	if _, err := c.PokeAsync([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun(0)); err != ErrClosed {
+		t.Errorf("expected %v got %v", ErrClosed, err)
+	}
+	if len(a.Peers) != 0 {
+		t.Errorf("expected no peers got %v", len(a.Peers))
+	}
+	if a.Close(context.Background()) != ErrClosed {
+		t.Errorf("expected %v", ErrClosed)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	interval := pumpInterval
+	pumpInterval = time.Millisecond
+	defer func() { pumpInterval = interval }()
+
+	a := &Ames{Peers: make(map[string]*Peer), opts: Options{FlushOnClose: true}}
+	peer := &Peer{Connections: make(map[int]*Connection)}
+	a.Peers["~zod"] = peer
+	c := pokeConnection()
+	c.pump = newPump(newFakeClock())
+	c.pump.Send(1, frags(2))
+	peer.Connections[1] = c
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := a.Flush(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v got %v", context.DeadlineExceeded, err)
+	}
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		c.mut.Lock()
+		c.pump.Ack(1, -1)
+		c.mut.Unlock()
+	}()
+	err = a.Close(context.Background())
+	if err != nil {
+		t.Errorf("expected %v got %v", nil, err)
+	}
+}
+
+// TestCloseWaitsForHandlers waits for a running plea handler, and ends
+// subscriptions whose facts aren't being read
+func TestCloseWaitsForHandlers(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	a, b, heard := subPair(t, func(p Plea) error {
+		if _, ok := p.(Poke); ok {
+			close(started)
+			<-release
+		}
+		return nil
+	})
+	defer b.Close(context.Background())
+
+	c, err := a.Connect(shipName(b.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := c.Subscribe("chat", []string{"updates"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextPlea(t, heard)
+	_, err = c.PokeAsync([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+
+	closed := make(chan error, 1)
+	go func() { closed <- b.Close(context.Background()) }()
+	select {
+	case <-closed:
+		t.Errorf("expected Close to wait for the handler")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err = <-closed:
+		if err != nil {
+			t.Errorf("expected %v got %v", nil, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Close to return once the handler did")
+	}
+
+	a.Close(context.Background())
+	if _, ok := <-sub.Facts; ok || sub.Err() != ErrClosed {
+		t.Errorf("expected %v got %v", ErrClosed, sub.Err())
+	}
+}
@@ -0,0 +1,159 @@
+package ames
+
+import (
+	"errors"
+	"math/big"
+	"net"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+// Protocol is a wire format, the version in bits 4-6 of every packet's
+// header. Only ames is spoken for now. Directed messaging, version 1,
+// is refused until it can be checked against the runtime
+type Protocol int
+
+const (
+	// ProtocolAmes is the shut packet format, version 0
+	ProtocolAmes Protocol = iota
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolAmes:
+		return "ames"
+	}
+	return "unknown"
+}
+
+// PacketVersion reads the protocol version from a packet's header
+func PacketVersion(pkt []byte) (Protocol, error) {
+	if len(pkt) < 4 {
+		return 0, errors.New("error: packet too short")
+	}
+	return Protocol(pkt[0] >> 4 & 0b111), nil
+}
+
+// codec puts a flow's shut packets, [bone num tag meat], on the wire in
+// one protocol and reads them back off it
+type codec interface {
+	encode(c *Connection, pat noun.Noun) ([]byte, error)
+	decode(a *Ames, pkt []byte) (Packet, *Connection, error)
+}
+
+var codecs = map[Protocol]codec{
+	ProtocolAmes: shutCodec{},
+}
+
+// shutCodec is the version 0 format, each shut packet encrypted whole
+type shutCodec struct{}
+
+func (shutCodec) encode(c *Connection, pat noun.Noun) ([]byte, error) {
+	symKey, life := c.Peer.keys()
+	pack, err := EncodeShutPacket(pat, symKey, c.ames.Ship, c.Peer.ship, c.ames.life(), life)
+	if err != nil {
+		return nil, err
+	}
+	return EncodePacket(pack), nil
+}
+
+func (shutCodec) decode(a *Ames, pkt []byte) (Packet, *Connection, error) {
+	header, body, err := decodeHeader(pkt)
+	if err != nil {
+		return Packet{}, &Connection{}, &Fault{Op: "decode", Err: err}
+	}
+	if !header.isAmes || header.version != 0 {
+		return Packet{}, &Connection{}, &Fault{Op: "decode", Err: errors.New("error: version invalid")}
+	}
+	from, to, fromTick, toTick, content := decodeBody(header, body)
+	var origin *net.UDPAddr
+	if header.relayed {
+		origin = DecodeLane(header.origin)
+	}
+
+	// a comet we haven't heard from sends its keys in an open packet
+	if rank(from) == cometRank && !a.knowsPeer(from) {
+		peer, err := a.hearOpen(from, to, content)
+		if err != nil {
+			return Packet{}, &Connection{}, &Fault{Op: "open", Ship: shipName(from), Err: err}
+		}
+		return Packet{Open: true, Origin: origin}, &Connection{ames: a, Peer: peer}, nil
+	}
+
+	peer, err := a.sender(from)
+	if err != nil {
+		return Packet{}, &Connection{}, &Fault{Op: "lookup", Ship: shipName(from), Err: err}
+	}
+
+	// a new life tick or a packet we can't read means the peer may
+	// have new keys or have breached. The lookup is too slow to wait
+	// for here, the packet is dropped and the peer will send it again
+	symKey, life, ourLife := a.inboundKeys(peer, toTick.Int64())
+	if fromTick.Int64() != life%16 {
+		a.resolveAsync(peer)
+	}
+	pat, err := DecodeShutPacket(content, symKey, from, to, fromTick, toTick, life, ourLife)
+	if err != nil {
+		a.resolveAsync(peer)
+		return Packet{}, &Connection{}, &Fault{Op: "decrypt", Ship: shipName(from), Err: err}
+	}
+	return a.shutToPacket(from, pat, origin)
+}
+
+// shutToPacket reads a decrypted shut packet from ship
+func (a *Ames) shutToPacket(from *big.Int, pat noun.Noun, origin *net.UDPAddr) (Packet, *Connection, error) {
+	bone, num, isFrag, meat, err := ShutPacketToMeat(pat)
+	if err != nil {
+		return Packet{}, &Connection{}, &Fault{Op: "decode", Ship: shipName(from), Err: err}
+	}
+
+	// the peer sends on its bone mixed with 1, which is the one we
+	// send on for the same flow
+	conn, err := a.GetConnection(from, bone^1)
+	if err != nil {
+		return Packet{}, &Connection{}, err
+	}
+
+	if isFrag {
+		_, fun, err := fragmentMeta(meat)
+		if err != nil {
+			return Packet{}, &Connection{}, err
+		}
+		packet := Packet{
+			Num:    num,
+			Fun:    fun,
+			Origin: origin,
+			meat:   meat,
+		}
+		return packet, conn, nil
+	}
+
+	// ack packet
+	a1, err := noun.AssertAtom(noun.Head(meat))
+	if err != nil {
+		return Packet{}, &Connection{}, err
+	}
+
+	// check if this is the final frag in msg
+	isFinal := noun.B(0).Cmp(a1.Value) != 0
+	var fun int
+	nack := false
+	if !isFinal {
+		f1, _ := noun.AssertAtom(noun.Tail(meat))
+		fun = int(f1.Value.Int64())
+	} else {
+		fun = -1
+		// [ok lag], ok is a loobean
+		ok, _ := noun.AssertAtom(noun.Head(noun.Tail(meat)))
+		nack = ok.Value != nil && ok.Value.Cmp(noun.B(0)) != 0
+	}
+
+	ack := Packet{
+		Ack:    true,
+		Num:    num,
+		Fun:    fun,
+		Origin: origin,
+		nack:   nack,
+	}
+	return ack, conn, nil
+}
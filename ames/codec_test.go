@@ -0,0 +1,28 @@
+package ames
+
+import "testing"
+
+func TestPacketVersion(t *testing.T) {
+	a := &Ames{}
+	pkt := []byte{0, 0, 0xfc, 0x0f}
+	v, err := PacketVersion(pkt)
+	if err != nil || v != ProtocolAmes {
+		t.Errorf("expected %v got %v %v", ProtocolAmes, v, err)
+	}
+
+	// directed messaging isn't spoken, its packets are refused
+	pkt[0] |= 1 << 4
+	v, err = PacketVersion(pkt)
+	if err != nil || v != 1 {
+		t.Errorf("expected %v got %v %v", 1, v, err)
+	}
+	_, _, err = a.ParsePacket(pkt)
+	if err == nil {
+		t.Errorf("expected a version error got %v", err)
+	}
+
+	_, err = PacketVersion([]byte{0})
+	if err == nil {
+		t.Errorf("expected a short packet error got %v", err)
+	}
+}
@@ -1,14 +1,15 @@
 package ames
 
 import (
-	"bufio"
+	"context"
 	"errors"
-	"fmt"
-	"io"
-	"log"
+	"log/slog"
 	"math/big"
 	"net"
+	"os"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/stevelacy/go-urbit/noun"
@@ -17,96 +18,300 @@ import (
 
 var ZOD = "~zod"
 
-type OnPacket func(c *Connection, pkt Packet)
+// maxPacketSize is the largest datagram we read
+const maxPacketSize = 8192
+
+// pumpInterval is how often each flow checks its retransmit timers
+var pumpInterval = 100 * time.Millisecond
+
+// OnPacket receives pleas sent to us and acks of our pokes
+type OnPacket func(c *Connection, ev Event)
+
+// Options configure an Ames instance, zero values use the defaults
+type Options struct {
+	// GalaxyTemplate builds a galaxy's address from its name, without
+	// the ~, and port. Defaults to %s.urbit.org:%d. A template that
+	// leaves out the port, as %d or %[2]d, is refused
+	GalaxyTemplate string
+	// Galaxies pins galaxies, such as ~zod, to a host:port
+	Galaxies map[string]string
+	// Handler serves pleas sent to us, deciding each ack. Without one
+	// pleas go to OnPacket and are always acked
+	Handler Handler
+	// FlushOnClose makes Close wait for sent messages to be acked
+	FlushOnClose bool
+	// Logger receives structured logs with peer, bone, num and fragment
+	// attributes. Nothing is logged without one
+	Logger *slog.Logger
+	// OnError is called with a *Fault for each non-fatal error, such as
+	// a bad checksum, failed decryption or failed lookup
+	OnError func(err error)
+	// Store persists flows across restarts, so they resume without a
+	// breach. Without one flows start again from scratch, which peers
+	// only accept after a breach
+	Store Store
+	// Lookup resolves a ship's keys, life and rift. It defaults to
+	// Lookup on azimuth
+	Lookup func(name string) (LookupResponse, error)
+	// OnBreach is called when a peer has breached, after our flows
+	// with it are reset
+	OnBreach func(ship string, rift int64)
+	// Keepalive configures the probes to our sponsor
+	Keepalive Keepalive
+	// Rift is our own rift. Flows saved under another rift are not
+	// restored
+	Rift int64
+	// Transport carries our packets, a UDP socket on a random port by
+	// default. It is closed by Close
+	Transport Transport
+	// Recorder is given every datagram sent and heard, such as a
+	// CaptureWriter for amesdump
+	Recorder Recorder
+	// Breach resets our flows with our parent on boot by poking
+	// helm-moon-breach. Only moons can be breached this way, and it is
+	// only needed without a Store
+	Breach bool
+}
 
+// Ames is safe for concurrent use. Each piece of state has its own
+// lock, none is held while taking another except Connection.sendMut and
+// Connection.mut around sends, and Ames.keyMut around peerMut and
+// Peer.keyMut
 type Ames struct {
-	PrivateKey [32]byte
-	breach     bool
-	Ship       *big.Int
-	Life       int64
-	RAddr      *net.UDPAddr
-	conn       *net.UDPConn
-	Peers      map[string]*Peer
-	connected  bool
+	keyMut        sync.RWMutex
+	PrivateKey    [32]byte // guarded by keyMut, as are Life and authKey
+	breach        bool
+	Ship          *big.Int
+	Life          int64
+	prevLife      int64        // our life before Rekey, guarded by keyMut
+	prevUntil     time.Time    // end of the grace window for prevLife
+	RAddr         *net.UDPAddr // our own galaxy, set once booted
+	conn          Transport
+	peerMut       sync.RWMutex
+	Peers         map[string]*Peer     // guarded by peerMut, use GetPeer
+	learning      map[string]bool      // ships being looked up, guarded by peerMut
+	restored      map[string]PeerState // saved without keys, guarded by peerMut
+	connected     atomic.Bool
+	probeInterval atomic.Int64  // between keepalive probes, as adapted
+	probed        chan struct{} // an answer to a keepalive probe
+	clock         clock
+	authKey       [32]byte // seed we sign with
+	mut           sync.Mutex
+	sponsor       *big.Int // guarded by mut
+	opts          Options
+	galaxyMut     sync.Mutex
+	galaxies      map[string]galaxyLane
+	scryMut       sync.Mutex
+	scries        map[string]*scryRequest
+	saveMut       sync.Mutex
+	dirty         atomic.Bool   // flows changed since the last save
+	badChecksums  atomic.Uint64 // packets dropped before their sender is known
+	quit          chan struct{} // closed by Close
+	closeOnce     sync.Once
+	spawnMut      sync.Mutex // orders spawn before closing quit
+	wg            sync.WaitGroup
 	OnPacket
 }
 
+// Connection is one flow with a peer. mut guards everything below it
 type Connection struct {
-	ames      *Ames
-	mut       sync.Mutex
-	bone, num int
-	Peer      *Peer
-	FragPool  map[int]map[int]Packet // pending frags num > fun > Packet
+	ames    *Ames
+	bone    int
+	Peer    *Peer
+	stats   counters
+	lastAck atomic.Int64 // unix nanos of the last ack heard
+	sendMut sync.Mutex   // held by queue from picking a num to sending it
+	mut     sync.Mutex
+	num     int
+	pump    *pump
+	sink    *sink
+	sub     *Subscription
+	// pokes waiting on their ack, and nacks and naxplanations waiting
+	// on each other. A nack's timer gives up on its naxplanation
+	futures       map[int]*PokeFuture
+	nacks         map[int]*time.Timer
+	naxplanations map[int]*NackError
+	msgs          map[int]noun.Noun // sent and not yet acked
+	inbox         []sinkMessage     // heard and waiting for serve
+	serving       bool              // serve is running
 }
 
 type Peer struct {
 	ship        *big.Int
-	pubKey      *big.Int
+	sponsor     *big.Int
+	keyMut      sync.RWMutex
+	pubKey      [32]byte // encryption key, guarded by keyMut as are all to resolved
+	authKey     [32]byte
 	symKey      []byte
+	prevSymKey  []byte // with our previous life, see Rekey
 	life        int64
-	Connections map[int]*Connection
+	rift        int64
+	resolved    time.Time   // last lookup
+	resolving   atomic.Bool // a lookup is running, see resolveAsync
+	mut         sync.Mutex
+	Connections map[int]*Connection // guarded by mut
 	nextBone    int
+	laneMut     sync.Mutex
+	lane        lane
+	relay       *net.UDPAddr // the peer's galaxy, resolved again after relayUntil
+	relayUntil  time.Time
+	relaying    atomic.Bool // resolving relay, see resolveRelayAsync
+	stats       counters
+	badDecrypts atomic.Uint64 // packets from the peer that didn't decrypt
 }
 
+// Packet is a single fragment or ack read from the wire
 type Packet struct {
-	Path []string
-	Mark string
-	Data noun.Noun
-	raw  []byte
-	Num  int
-	Fun  int // Frag num
+	Ack    bool
+	Num    int
+	Fun    int          // Frag num
+	Origin *net.UDPAddr // sender's lane if the packet was relayed
+	Open   bool         // a comet's attestation, it carries no message
+	meat   noun.Noun
+	nack   bool
 }
 
+// NewAmes boots with default options, saving flows to a FileStore in
+// the user cache dir so the next run with the same seed resumes them.
+// Without a cache dir it breaches on boot instead
 func NewAmes(seed string, onPacket OnPacket) (*Ames, error) {
-	bSeed, ok := hexSeedToBig(seed)
-	if !ok {
-		return &Ames{}, errors.New("Invalid seed value or encoding provided")
+	id, err := NewKeyfileIdentity(seed)
+	if err != nil {
+		return &Ames{}, err
 	}
-	shp, life, privKey, err := ParseSeed(bSeed)
-	ames := &Ames{
-		breach:     true,
-		Ship:       shp,
-		Life:       life.Int64(),
-		PrivateKey: privKey,
-		Peers:      make(map[string]*Peer),
-		OnPacket:   onPacket,
+	return NewAmesWithIdentity(id, onPacket, defaultOptions(id.Ship))
+}
+
+// defaultOptions keep the flows of ship in the user cache dir
+func defaultOptions(ship *big.Int) Options {
+	dir, err := os.UserCacheDir()
+	if err == nil {
+		dir = filepath.Join(dir, "go-urbit")
+		err = os.MkdirAll(dir, 0o700)
 	}
-	raddr, err := net.ResolveUDPAddr("udp", zodAddr)
 	if err != nil {
-		return ames, err
+		return Options{Breach: true}
 	}
+	return Options{Store: NewFileStore(filepath.Join(dir, shipName(ship)+".json"))}
+}
 
-	ames.RAddr = raddr
+// NewAmesWithOptions is NewAmes with routing configured by opts. The
+// seed is a moon seed or the network keyfile of any ship
+func NewAmesWithOptions(seed string, onPacket OnPacket, opts Options) (*Ames, error) {
+	id, err := NewKeyfileIdentity(seed)
+	if err != nil {
+		return &Ames{}, err
+	}
+	return NewAmesWithIdentity(id, onPacket, opts)
+}
 
-	// create local listener with random port
-	conn, err := net.ListenUDP("udp", nil)
-	conn.SetReadBuffer(4096 * 16)
+// NewAmesWithIdentity runs as any ship, including a comet from NewComet
+func NewAmesWithIdentity(id *Identity, onPacket OnPacket, opts Options) (*Ames, error) {
+	return NewAmesContext(context.Background(), id, onPacket, opts)
+}
+
+// NewAmesContext is NewAmesWithIdentity with booting bounded by ctx,
+// which otherwise waits as long as it takes for our sponsor to answer
+func NewAmesContext(ctx context.Context, id *Identity, onPacket OnPacket, opts Options) (*Ames, error) {
+	ames, err := newAmes(id.Ship, id.Life, id.CryptKey, id.AuthKey, onPacket, opts)
 	if err != nil {
 		return ames, err
 	}
+	err = ames.restore()
+	if err == nil {
+		err = ames.boot(ctx)
+	}
+	if err != nil {
+		ames.Close(context.Background())
+	}
+	return ames, err
+}
+
+// newAmes listens on a random port and starts the read and retry loops
+func newAmes(ship *big.Int, life int64, privKey, authKey [32]byte, onPacket OnPacket, opts Options) (*Ames, error) {
+	err := checkGalaxyTemplate(opts.GalaxyTemplate)
+	if err != nil {
+		return &Ames{}, err
+	}
+	ames := &Ames{
+		breach:     opts.Breach,
+		Ship:       ship,
+		Life:       life,
+		PrivateKey: privKey,
+		authKey:    authKey,
+		Peers:      make(map[string]*Peer),
+		clock:      systemClock{},
+		opts:       opts,
+		quit:       make(chan struct{}),
+		probed:     make(chan struct{}, 1),
+		OnPacket:   onPacket,
+	}
+
+	// create local listener with random port
+	conn := opts.Transport
+	if conn == nil {
+		var err error
+		conn, err = ListenUDP(nil)
+		if err != nil {
+			return ames, err
+		}
+	}
+	if opts.Recorder != nil {
+		conn = recordTransport{conn, opts.Recorder, ames.clock}
+	}
 	ames.conn = conn
 
 	// handle all incoming packets
+	ames.wg.Add(2)
 	go ames.handleConn()
 	go ames.handleRetries()
+	return ames, nil
+}
 
-	// breach parent
-	parent := noun.B(0).Mod(ames.Ship, noun.Bex(noun.B(32)))
+// boot connects to our sponsor, breaching first if Options.Breach is
+// set, and waits until ctx is done for it to hear us
+func (a *Ames) boot(ctx context.Context) error {
+	sponsor, err := a.lookupSponsor(a.Ship)
+	if err != nil {
+		return err
+	}
+	a.mut.Lock()
+	a.sponsor = sponsor
+	a.mut.Unlock()
 
-	patp, err := noun.BN2patp(parent)
-	fmt.Println("connecting to:", patp)
+	// packets go through our galaxy until peers learn our lane
+	chain, err := a.SponsorChain(a.Ship)
 	if err != nil {
-		return ames, err
+		return err
+	}
+	raddr, err := a.galaxyAddr(chain[len(chain)-1])
+	if err != nil {
+		return err
 	}
+	a.mut.Lock()
+	a.RAddr = raddr
+	a.mut.Unlock()
 
-	c, err := ames.Connect(patp)
+	// breach parent
+	patp, err := noun.BN2patp(sponsor)
+	a.logger().Info("connecting to sponsor", "peer", patp)
 	if err != nil {
-		return ames, err
+		return err
+	}
+
+	c, err := a.Connect(patp)
+	if err != nil {
+		return err
+	}
+	// booting can wait for the relay, sends only start resolving it
+	_, err = a.resolveRelay(c.Peer)
+	if err != nil {
+		return err
 	}
 
 	// breach moon before connecting
 	// this prevents bone and message num conflicts
-	if c.ames.breach == true {
+	if c.ames.breach == true && rank(a.Ship) == moonRank {
 		// breach with helm-moon-breach
 		pkt, err := c.CreateMessage(
 			[]string{"ge", "hood"},
@@ -114,65 +319,62 @@ func NewAmes(seed string, onPacket OnPacket) (*Ames, error) {
 			noun.MakeNoun(c.ames.Ship),
 		)
 		if err != nil {
-			return ames, err
+			return err
 		}
-		_, err = c.ames.SendPacket(pkt[0])
+		err = c.write(pkt[0])
 
 		if err != nil {
-			return ames, err
+			return err
 		}
 	}
 	// delay for zod to catch up
-	time.Sleep(5 * time.Second)
-
-	// ping zod after breach
-	err = ames.initZod()
-	if err != nil {
-		return ames, err
-	}
-	// wait until zod responds as connected
-	for ames.connected == false {
-		time.Sleep(1 * time.Second)
-	}
-	return ames, err
-}
-
-// initZod initiates the ames vane through zod
-func (a *Ames) initZod() error {
-	c, err := a.Connect(ZOD)
-
-	pkt, err := c.CreateMessage(
-		[]string{"ge", "hood"},
-		"helm-hi",
-		noun.MakeNoun("urbit-go"),
-	)
+	err = a.wait(ctx, 5*time.Second)
 	if err != nil {
 		return err
 	}
 
-	_, err = a.SendPacket(pkt[0])
+	if a.opts.Keepalive.Disabled {
+		return nil
+	}
+	// probe our sponsor after breach
+	err = a.startKeepalive()
 	if err != nil {
 		return err
 	}
-
-	// ping zod every 25 seconds
-	ticker := time.NewTicker(25 * time.Second)
-	count := 1
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				_, err = a.SendPacket(pkt[0])
-				count++
-			}
+	// wait until our sponsor responds as connected
+	for !a.connected.Load() {
+		err = a.wait(ctx, time.Second)
+		if err != nil {
+			return err
 		}
-	}()
+	}
+	return nil
+}
 
-	return err
+// wait is sleep bounded by ctx
+func (a *Ames) wait(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-a.quit:
+		return ErrClosed
+	}
 }
 
-func (a *Ames) newPeer(name *big.Int) (Peer, error) {
-	peer := Peer{
+// sleep waits for d, returning false if we closed first
+func (a *Ames) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-a.quit:
+		return false
+	}
+}
+
+func (a *Ames) newPeer(name *big.Int) (*Peer, error) {
+	peer := &Peer{
 		ship:        name,
 		nextBone:    1,
 		Connections: make(map[int]*Connection),
@@ -182,15 +384,17 @@ func (a *Ames) newPeer(name *big.Int) (Peer, error) {
 	if err != nil {
 		return peer, err
 	}
+	// comets aren't on azimuth, their keys come in an open packet
+	if rank(name) == cometRank {
+		return peer, errors.New("no attestation from comet " + bnp)
+	}
 	// query to addr on eth
-	ethRes, err := Lookup(bnp)
+	ethRes, err := a.lookup(bnp)
 	if err != nil {
 		return peer, err
 	}
-	symKey := a.GenerateSymKey(ethRes.EncryptionKey)
-
-	peer.symKey = symKey
-	peer.life = ethRes.Life
+	a.setKeys(peer, ethRes)
+	peer.sponsor = azimuthSponsor(name, ethRes)
 	return peer, nil
 }
 
@@ -199,25 +403,96 @@ func (a *Ames) GetPeer(name *big.Int) (*Peer, error) {
 	if err != nil {
 		return &Peer{}, err
 	}
+	a.peerMut.RLock()
 	peer, ok := a.Peers[n]
+	a.peerMut.RUnlock()
 	if ok {
 		return peer, nil
 	}
+	// the lookup is slow so it runs unlocked, the first one in wins
 	p, err := a.newPeer(name)
 	if err != nil {
 		return &Peer{}, err
 	}
-	a.Peers[n] = &p
-	return &p, nil
+	p, added := a.addPeer(n, p)
+	if !added {
+		return p, nil
+	}
+	a.peerMut.Lock()
+	ps, restore := a.restored[n]
+	delete(a.restored, n)
+	a.peerMut.Unlock()
+	if restore {
+		err = a.restoreFlows(p, ps)
+		if err != nil {
+			a.fault("save", name, err)
+		}
+	}
+	return p, nil
+}
+
+// addPeer adds p unless another peer got in first, returning the one
+// kept. p's symmetric key is derived again as it is added, both under
+// keyMut, so a Rekey either finds p or p has the new key
+func (a *Ames) addPeer(name string, p *Peer) (*Peer, bool) {
+	a.keyMut.RLock()
+	defer a.keyMut.RUnlock()
+	a.peerMut.Lock()
+	defer a.peerMut.Unlock()
+	if known, ok := a.Peers[name]; ok {
+		return known, false
+	}
+	p.keyMut.Lock()
+	if p.pubKey != ([32]byte{}) {
+		p.symKey = urcrypt.UrcryptEdShar(p.pubKey, a.PrivateKey)
+	}
+	p.keyMut.Unlock()
+	a.Peers[name] = p
+	return p, true
+}
+
+// peers returns a snapshot of the known peers
+func (a *Ames) peers() []*Peer {
+	a.peerMut.RLock()
+	defer a.peerMut.RUnlock()
+	peers := make([]*Peer, 0, len(a.Peers))
+	for _, p := range a.Peers {
+		peers = append(peers, p)
+	}
+	return peers
+}
+
+// connections returns a snapshot of every flow with every peer
+func (a *Ames) connections() []*Connection {
+	conns := []*Connection{}
+	for _, p := range a.peers() {
+		p.mut.Lock()
+		for _, c := range p.Connections {
+			conns = append(conns, c)
+		}
+		p.mut.Unlock()
+	}
+	return conns
 }
 
+// Connect opens a new flow with the ship
 func (a *Ames) Connect(name string) (*Connection, error) {
 	p, err := noun.Patp2bn(name)
 	if err != nil {
 		return &Connection{}, err
 	}
 	peer, err := a.GetPeer(p)
-	return a.GetConnection(p, peer.nextBone)
+	if err != nil {
+		return &Connection{}, err
+	}
+	return a.openConnection(peer), nil
+}
+
+// openConnection creates a flow on the peer's next bone
+func (a *Ames) openConnection(peer *Peer) *Connection {
+	peer.mut.Lock()
+	defer peer.mut.Unlock()
+	return a.newConnection(peer, peer.nextBone)
 }
 
 // GetConnection retrieves or creates a Connection
@@ -226,223 +501,512 @@ func (a *Ames) GetConnection(p *big.Int, bone int) (*Connection, error) {
 	if err != nil {
 		return &Connection{}, err
 	}
+	peer.mut.Lock()
+	defer peer.mut.Unlock()
 	cn, ok := peer.Connections[bone]
 	if ok {
 		return cn, nil
 	}
+	return a.newConnection(peer, bone), nil
+}
+
+// connection returns the flow on bone if there is one
+func (p *Peer) connection(bone int) (*Connection, bool) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	c, ok := p.Connections[bone]
+	return c, ok
+}
+
+// newConnection must be called with peer.mut held
+func (a *Ames) newConnection(peer *Peer, bone int) *Connection {
 	c := &Connection{
-		Peer:     peer,
-		bone:     bone,
-		ames:     a,
-		FragPool: make(map[int]map[int]Packet),
-		num:      1,
+		Peer: peer,
+		bone: bone,
+		ames: a,
+		pump: newPump(a.clock),
+		sink: newSink(),
+		num:  1,
+
+		futures:       make(map[int]*PokeFuture),
+		nacks:         make(map[int]*time.Timer),
+		naxplanations: make(map[int]*NackError),
+		msgs:          make(map[int]noun.Noun),
 	}
 	peer.Connections[bone] = c
-	peer.nextBone += 4
-	return c, nil
+	// flows opened by the peer don't use up one of our bones
+	if bone == peer.nextBone {
+		peer.nextBone += 4
+	}
+	a.dirty.Store(true)
+	return c
 }
 
 func (a *Ames) GenerateSymKey(encryptionKey string) []byte {
-	theirPubkey := noun.B(0)
-	theirPubkey.SetString(encryptionKey, 16)
-	var pubTheirKeyArr [32]byte
-	copy(pubTheirKeyArr[:], noun.BigToLittle(theirPubkey))
-	return urcrypt.UrcryptEdShar(pubTheirKeyArr, a.PrivateKey)
+	a.keyMut.RLock()
+	defer a.keyMut.RUnlock()
+	return urcrypt.UrcryptEdShar(keyFromHex(encryptionKey), a.PrivateKey)
+}
+
+// keyFromHex reads a public key from its azimuth hex form
+func keyFromHex(key string) [32]byte {
+	k := noun.B(0)
+	k.SetString(key, 16)
+	var arr [32]byte
+	copy(arr[:], noun.BigToLittle(k))
+	return arr
 }
 
 // Request sends a mark and data (noun) to a connected ship
 func (c *Connection) Request(path []string, mark string, data noun.Noun) ([][]byte, error) {
-	c.mut.Lock()
-	defer c.mut.Unlock()
+	_, pkts, err := c.send(ConstructPoke(path, mark, data))
+	return pkts, err
+}
 
-	pkts, err := c.CreateMessage(path, mark, data)
+// sendPlea queues a typed plea on the flow, returning its message num
+func (c *Connection) sendPlea(p Plea) (int, error) {
+	msg, err := EncodePlea(p)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	for k, pkt := range pkts {
-		// add to pending pool
-		if len(c.FragPool[c.num]) == 0 {
-			c.FragPool[c.num] = make(map[int]Packet)
-		}
+	num, _, err := c.send(msg)
+	return num, err
+}
 
-		c.FragPool[c.num][k] = Packet{
-			Path: path,
-			Mark: mark,
-			Data: data,
-			raw:  pkt,
-			Num:  c.num,
-			Fun:  k,
-		}
-		_, err = c.ames.SendPacket(pkt)
+// send queues a message on the flow, returning its message num
+func (c *Connection) send(msg noun.Noun) (int, [][]byte, error) {
+	return c.queue(msg, nil)
+}
+
+// queue journals the message under the flow's next num and then sends
+// it, so a restart never reuses a num the peer may have heard. Nothing
+// goes out if the journal fails. f, if any, waits on the ack
+func (c *Connection) queue(msg noun.Noun, f *PokeFuture) (int, [][]byte, error) {
+	// the num is ours until we're done, the journal runs unlocked
+	c.sendMut.Lock()
+	defer c.sendMut.Unlock()
+	c.mut.Lock()
+	num := c.num
+	pkts, err := c.createMessage(msg)
+	c.mut.Unlock()
+	if err != nil {
+		return num, nil, err
 	}
+	err = c.ames.saveSent(c, num, msg)
+	if err != nil {
+		return num, nil, err
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	// registered before sending, the ack can beat us back
+	if f != nil {
+		f.Num = num
+		c.futures[num] = f
+	}
+	c.msgs[num] = msg
+	// the pump decides how many frags can go out now
+	c.pump.Send(num, pkts)
+	next := c.pump.Next()
+	if rank(c.ames.Ship) == cometRank {
+		next = append([][]byte{c.ames.attestation(c.Peer)}, next...)
+	}
+	for _, pkt := range next {
+		err = c.write(pkt)
+	}
+	// the pump sends it again once the relay is found
+	if errors.Is(err, ErrLookingUp) {
+		err = nil
+	}
+	c.debug("sent message", "num", num, "fragments", len(pkts))
 	// increment num after sending frags
 	c.num++
-	return pkts, err
+	// queued even if writing it failed
+	return num, pkts, err
 }
 
 func (c *Connection) CreateMessage(path []string, mark string, data noun.Noun) ([][]byte, error) {
-	poke := ConstructPoke(path, mark, data)
-	msgs := SplitMessage(c.num, poke)
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.createMessage(ConstructPoke(path, mark, data))
+}
+
+func (c *Connection) createMessage(msg noun.Noun) ([][]byte, error) {
+	return c.encodeMessage(c.num, msg)
+}
+
+// encodeMessage splits message num into encrypted fragments
+func (c *Connection) encodeMessage(num int, msg noun.Noun) ([][]byte, error) {
+	msgs := SplitMessage(num, msg)
 	var packets [][]byte
 	for _, msg := range msgs {
 
 		pat := FragmentToShutPacket(msg, c.bone)
-		pack, err := EncodeShutPacket(pat, c.Peer.symKey, c.ames.Ship, c.Peer.ship, c.ames.Life, c.Peer.life)
+		packet, err := c.encode(pat)
 		if err != nil {
 			return [][]byte{}, err
 		}
-		packet := EncodePacket(pack)
 		packets = append(packets, packet)
 	}
 
 	return packets, nil
 }
 
-// handleRetries will retry all packets in each FragPool
-func (a *Ames) handleRetries() {
-	for range time.Tick(time.Second * 10) {
-		for _, p := range a.Peers {
-			for _, c := range p.Connections {
-				for _, pool := range c.FragPool {
-					for _, packet := range pool {
-						_, err := c.ames.SendPacket(packet.raw)
-						if err != nil {
-							log.Fatal(err)
-						}
-					}
-				}
-			}
+// encode puts a shut packet on the wire
+func (c *Connection) encode(pat noun.Noun) ([]byte, error) {
+	return codecs[ProtocolAmes].encode(c, pat)
+}
+
+// hear passes a fragment to the sink. Messages it completes are
+// handled in order by serve, so the read loop never waits on a handler
+func (c *Connection) hear(packet Packet) error {
+	// probes repeat message 0, any other repeat may be a breached peer
+	// starting its flows over. It isn't acked until we have checked
+	c.mut.Lock()
+	repeat := packet.Num > 0 && packet.Num <= c.sink.lastAcked
+	c.mut.Unlock()
+	if repeat && c.ames.recheck(c.Peer) {
+		return nil
+	}
+
+	c.mut.Lock()
+	acks, msgs, err := c.sink.Hear(packet.Num, packet.meat)
+	c.inbox = append(c.inbox, msgs...)
+	start := len(c.inbox) > 0 && !c.serving
+	if start {
+		c.serving = true
+	}
+	c.mut.Unlock()
+	if err != nil {
+		return err
+	}
+	if start && !c.ames.spawn(c.serve) {
+		c.mut.Lock()
+		c.serving = false
+		c.mut.Unlock()
+	}
+
+	for _, ack := range acks {
+		err = c.sendAck(ack)
+		if err != nil {
+			return err
 		}
 	}
+	return nil
 }
 
-func (a *Ames) handleConn() {
+// serve handles the flow's messages one at a time in order until none
+// are left or we close. Each is saved and then acked, or nacked, once
+// handled
+func (c *Connection) serve() {
 	for {
-		buf := make([]byte, 0)
-		tmp := make([]byte, 4096)
+		c.mut.Lock()
+		if len(c.inbox) == 0 || c.ames.isClosed() {
+			c.serving = false
+			c.mut.Unlock()
+			return
+		}
+		m := c.inbox[0]
+		c.inbox = c.inbox[1:]
+		c.mut.Unlock()
 
-		for {
-			ln, err := bufio.NewReader(a.conn).Read(tmp)
+		err := c.handle(m)
+		if err != nil {
+			err = c.nack(m.num, nackError(nil, err))
 			if err != nil {
-				fmt.Println("close:", err)
-				if err != io.EOF {
-					fmt.Println("conn error:", err)
-				}
-				break
-			}
-			buf = append(buf, tmp[:ln]...)
-			if ln < 4096 {
-				break
+				c.ames.fault("nack", c.Peer.ship, err)
 			}
 		}
-		packet, c, err := a.ParsePacket(buf)
+		c.mut.Lock()
+		c.sink.Done(m.num)
+		c.mut.Unlock()
+		// the peer forgets the message once it hears the ack
+		c.ames.saveAcked(c, m.num)
+		err = c.sendAck(sinkAck{m.num, -1})
 		if err != nil {
-			fmt.Println(err)
-			return
+			c.ames.fault("ack", c.Peer.ship, err)
 		}
+	}
+}
 
-		// if this is an ack remove the packet from pending frags
-		if packet.Mark == "ack" {
-			for _, p := range a.Peers {
-				for _, c := range p.Connections {
-					for num, pool := range c.FragPool {
-						if num == packet.Num {
-							if packet.Fun == -1 {
-								// final packet, delete whole num
-								delete(c.FragPool, num)
-								continue
-							}
-
-							for fun, packet := range pool {
-								if fun == packet.Fun {
-									delete(pool, fun)
-								}
-							}
-						}
-					}
-				}
-			}
-
+// handle passes a message to whatever it is for, an error nacks it
+func (c *Connection) handle(m sinkMessage) error {
+	// the flow on our bone mixed with 2 explains nacks of our messages
+	if c.bone&2 != 0 {
+		num, nerr, err := DecodeNaxplanation(m.msg)
+		if err != nil {
+			return err
 		}
-		// if res is from zod
-		if c.Peer.ship.Cmp(noun.B(0)) == 0 && !a.connected {
-			// we are now connected
-			a.connected = true
+		if orig, ok := c.Peer.connection(c.bone ^ 2); ok {
+			orig.onNaxplanation(num, nerr)
 		}
-
-		// messages
-		if a.OnPacket != nil && packet.Mark != "ack" {
-			a.OnPacket(c, packet)
+		return nil
+	}
+	// on a subscription flow everything we hear is a boon
+	if sub := c.subscription(); sub != nil {
+		boon, err := DecodeBoon(m.msg)
+		if err != nil {
+			return err
 		}
+		sub.onBoon(boon)
+		return nil
+	}
+	plea, err := DecodePlea(m.msg)
+	if err == nil {
+		err = c.ames.servePlea(c, plea)
 	}
-}
-
-// ParsePacket is the reverse of CreateMessage
-func (a *Ames) ParsePacket(pkt []byte) (Packet, *Connection, error) {
-	from, to, fromTick, toTick, content, err := DecodePacket(pkt)
 	if err != nil {
-		return Packet{}, &Connection{}, err
+		return nackError(plea, err)
 	}
+	return nil
+}
 
-	peer, err := a.GetPeer(from)
+// nack marks the message nacked and sends the naxplanation, which goes
+// out before the nack itself
+func (c *Connection) nack(num int, err *NackError) error {
+	c.ames.logger().Info("nacking plea", "peer", shipName(c.Peer.ship), "bone", c.bone, "num", num, "err", err)
+	c.mut.Lock()
+	c.sink.Nack(num)
+	c.mut.Unlock()
 
-	if err != nil {
-		return Packet{}, &Connection{}, err
+	nc, e := c.ames.GetConnection(c.Peer.ship, c.bone^2)
+	if e != nil {
+		return e
 	}
+	_, _, e = nc.send(EncodeNaxplanation(num, err))
+	return e
+}
 
-	pat, err := DecodeShutPacket(content, peer.symKey, from, to, fromTick, toTick, peer.life, a.Life)
-	if err != nil {
-		return Packet{}, &Connection{}, err
+// onMessageAck reports the ack of one of our messages
+func (c *Connection) onMessageAck(num int, nack bool) {
+	// acks of our naxplanations
+	if c.bone&2 != 0 {
+		return
 	}
-	bone, num, isFrag, meat, err := ShutPacketToMeat(pat)
+	if sub := c.subscription(); sub != nil {
+		sub.onAck(num, nack)
+		return
+	}
+	c.onPokeAck(num, nack)
+}
+
+func (c *Connection) subscription() *Subscription {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.sub
+}
+
+func (c *Connection) sendAck(ack sinkAck) error {
+	var pat noun.Noun
+	if ack.fun == -1 {
+		c.mut.Lock()
+		ok := !c.sink.Nacked(ack.num)
+		c.mut.Unlock()
+		pat = MessageAckToShutPacket(c.bone, ack.num, ok)
+	} else {
+		pat = FragmentAckToShutPacket(c.bone, ack.num, ack.fun)
+	}
+	pkt, err := c.encode(pat)
 	if err != nil {
-		return Packet{}, &Connection{}, err
+		return err
 	}
+	err = c.write(pkt)
+	// the peer sends again until it hears the ack
+	if errors.Is(err, ErrLookingUp) {
+		return nil
+	}
+	return err
+}
 
-	conn, err := a.GetConnection(from, bone)
-	if isFrag {
-		msg, err := JoinMessage([]noun.Noun{meat})
-		if err != nil {
-			return Packet{}, &Connection{}, err
+// handleRetries runs the pump of every connection, resending expired
+// fragments and sending queued ones as the window opens
+func (a *Ames) handleRetries() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(pumpInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-a.quit:
+			return
 		}
-		path, mark, data, err := DestructPoke(msg)
-		packet := Packet{
-			Path: path,
-			Mark: mark,
-			Data: data,
-			Num:  num,
+		for _, c := range a.connections() {
+			c.runPump()
 		}
+		if a.dirty.Load() {
+			err := a.save()
+			if err != nil {
+				a.fault("save", nil, err)
+			}
+		}
+	}
+}
 
-		return packet, conn, err
+// runPump sends whatever the pump has ready. A failed send is left live
+// and will be picked up again by its retransmit timer
+func (c *Connection) runPump() {
+	c.mut.Lock()
+	retransmits := c.pump.Retransmits()
+	pkts := append(retransmits, c.pump.Next()...)
+	c.mut.Unlock()
+	c.stats.retransmits.Add(uint64(len(retransmits)))
+	c.Peer.stats.retransmits.Add(uint64(len(retransmits)))
+	// a peer that breached drops what we send on flows it forgot
+	if len(retransmits) > 0 {
+		c.ames.recheck(c.Peer)
 	}
 
-	// ack packet
-	a1, err := noun.AssertAtom(noun.Head(meat))
-	if err != nil {
-		return Packet{}, &Connection{}, err
+	if len(pkts) > 0 {
+		c.debug("pump", "packets", len(pkts))
 	}
+	for _, pkt := range pkts {
+		err := c.write(pkt)
+		if err != nil {
+			c.ames.fault("send", c.Peer.ship, err)
+		}
+	}
+}
 
-	// check if this is the final frag in msg
-	isFinal := noun.B(0).Cmp(a1.Value) != 0
-	var fun int
-	if !isFinal {
-		f1, _ := noun.AssertAtom(noun.Tail(meat))
-		fun = int(f1.Value.Int64())
-	} else {
-		fun = -1
+func (a *Ames) handleConn() {
+	defer a.wg.Done()
+	tmp := make([]byte, maxPacketSize)
+	for {
+		ln, src, err := a.conn.ReadFrom(tmp)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || a.isClosed() {
+				return
+			}
+			a.fault("read", nil, err)
+			continue
+		}
+		buf := make([]byte, ln)
+		copy(buf, tmp[:ln])
+
+		if v, _ := PacketVersion(buf); v == ProtocolAmes && !isAmesPacket(buf) {
+			err := a.onFineResponse(buf, src)
+			if err != nil {
+				a.fault("scry", nil, err)
+			}
+			continue
+		}
+
+		// a bad packet shouldn't stop us hearing the next one
+		packet, c, err := a.ParsePacket(buf)
+		if err != nil {
+			a.countFault(err)
+			a.fault("decode", nil, err)
+			continue
+		}
+		c.received(ln)
+
+		// if res is from our sponsor we are now connected
+		if a.isSponsor(c.Peer.ship) {
+			a.connected.Store(true)
+		}
+		// only learn lanes from packets that decrypted
+		c.Peer.learnLane(src, packet.Origin, a.clock.Now())
+		if packet.Open {
+			continue
+		}
+
+		// message 0 is only ever a keepalive probe
+		if packet.Ack && packet.Num == 0 {
+			a.onProbeAck()
+			continue
+		}
+		// if this is an ack remove the packet from the pump
+		if packet.Ack {
+			c.debug("heard ack", "num", packet.Num, "fragment", packet.Fun, "nack", packet.nack)
+			c.lastAck.Store(a.clock.Now().UnixNano())
+			c.mut.Lock()
+			c.pump.Ack(packet.Num, packet.Fun)
+			if packet.Fun == -1 {
+				delete(c.msgs, packet.Num)
+				a.dirty.Store(true)
+			}
+			c.mut.Unlock()
+			c.runPump()
+			if packet.Fun == -1 {
+				c.onMessageAck(packet.Num, packet.nack)
+			}
+			continue
+		}
+
+		// messages are delivered by the sink once complete and in order
+		c.debug("heard fragment", "num", packet.Num, "fragment", packet.Fun)
+		err = c.hear(packet)
+		if err != nil {
+			a.fault("deliver", c.Peer.ship, err)
+		}
 	}
+}
 
-	ack := Packet{
-		Path: []string{""},
-		Mark: "ack",
-		Data: noun.MakeNoun(0),
-		Num:  num,
-		Fun:  fun,
+// ParsePacket is the reverse of CreateMessage for a single fragment or
+// ack, in whichever protocol the packet's version says
+func (a *Ames) ParsePacket(pkt []byte) (Packet, *Connection, error) {
+	v, err := PacketVersion(pkt)
+	if err != nil {
+		return Packet{}, &Connection{}, &Fault{Op: "decode", Err: err}
+	}
+	codec, ok := codecs[v]
+	if !ok {
+		return Packet{}, &Connection{}, &Fault{Op: "decode", Err: errors.New("error: version invalid")}
 	}
-	return ack, conn, nil
+	return codec.decode(a, pkt)
 }
 
 // SendPacket writes the packet input to the connected target
 func (a *Ames) SendPacket(pkt []byte) (int, error) {
-	return a.conn.WriteToUDP(pkt, a.RAddr)
+	a.mut.Lock()
+	raddr := a.RAddr
+	a.mut.Unlock()
+	return a.conn.WriteTo(pkt, raddr)
+}
+
+func (a *Ames) isSponsor(ship *big.Int) bool {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	return a.sponsor != nil && a.sponsor.Cmp(ship) == 0
+}
+
+// sendTo writes the packet on the peer's direct lane, the relay, or
+// both while the direct lane is unconfirmed
+func (a *Ames) sendTo(peer *Peer, pkt []byte) error {
+	now := a.clock.Now()
+	// a fresh direct lane is used alone, without finding the relay
+	var relay *net.UDPAddr
+	var err error
+	if !peer.hasDirectLane(now) {
+		relay, err = a.relay(peer)
+	}
+	routes := peer.routes(relay, now)
+	if len(routes) == 0 {
+		return err
+	}
+	err = nil
+	sent := false
+	for _, addr := range routes {
+		_, e := a.conn.WriteTo(pkt, addr)
+		if e != nil {
+			err = e
+			if relay != nil && addr.String() == relay.String() && !errors.Is(e, net.ErrClosed) {
+				a.expireRelay(peer, relay)
+			}
+		} else {
+			sent = true
+		}
+	}
+	if sent {
+		peer.stats.sent(len(pkt))
+	}
+	return err
+}
+
+// write puts one of the flow's packets on the wire to the peer
+func (c *Connection) write(pkt []byte) error {
+	err := c.ames.sendTo(c.Peer, pkt)
+	if err == nil {
+		c.stats.sent(len(pkt))
+	}
+	return err
 }
@@ -1,9 +1,15 @@
 package ames
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stevelacy/go-urbit/noun"
 )
@@ -14,13 +20,16 @@ func TestConnectionMoon(t *testing.T) {
 		t.Errorf("Please define env var MOON_SEED")
 	}
 
-	onPacket := func(c *Connection, pkt Packet) {
-		fmt.Println("ames OnPacket", pkt.Data)
+	onPacket := func(c *Connection, ev Event) {
+		fmt.Println("ames OnPacket", ev)
 	}
-	ames, err := NewAmes(seed, onPacket)
+	// kept between runs so the moon needs no breach
+	store := NewFileStore(filepath.Join(os.TempDir(), "ames-moon-test.json"))
+	ames, err := NewAmesWithOptions(seed, onPacket, Options{Store: store})
 	if err != nil {
 		t.Error(err)
 	}
+	defer ames.Close(context.Background())
 
 	to := "~litryl-tadmev"
 
@@ -59,6 +68,7 @@ func ExampleNewAmes() {
 	if err != nil {
 		panic(err)
 	}
+	defer ames.Close(context.Background())
 	to := "~litryl-tadmev"
 	conn, err := ames.Connect(to)
 	if err != nil {
@@ -66,3 +76,115 @@ func ExampleNewAmes() {
 	}
 	conn.Request([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("message here"))
 }
+
+// testPair returns two ames on localhost that know each other, with a
+// shared key so no lookups are needed
+func testPair(t *testing.T) (*Ames, *Ames) {
+	return testPairOptions(t, Options{})
+}
+
+// testPairOptions is testPair with options for the first ship
+func testPairOptions(t *testing.T, opts Options) (*Ames, *Ames) {
+	a, err := newAmes(noun.B(0x10100), 1, [32]byte{}, [32]byte{}, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newAmes(noun.B(0x10200), 1, [32]byte{}, [32]byte{}, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	introduce(a, b)
+	introduce(b, a)
+	return a, b
+}
+
+// introduce adds y as a peer of x, reached directly on localhost
+func introduce(x, y *Ames) {
+	symKey := []byte("0123456789abcdef0123456789abcdef")
+	name, _ := noun.BN2patp(y.Ship)
+	x.peerMut.Lock()
+	defer x.peerMut.Unlock()
+	x.Peers[name] = &Peer{
+		ship:        y.Ship,
+		symKey:      symKey,
+		life:        y.Life,
+		nextBone:    1,
+		Connections: make(map[int]*Connection),
+	}
+	pinRelay(x.Peers[name], &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: y.conn.LocalAddr().Port})
+}
+
+// pinRelay sends to the peer through addr instead of its galaxy
+func pinRelay(p *Peer, addr *net.UDPAddr) {
+	p.laneMut.Lock()
+	defer p.laneMut.Unlock()
+	p.relay = addr
+	p.relayUntil = time.Now().Add(time.Hour)
+}
+
+// TestConcurrentPokes hammers Connect, Poke and receiving from both
+// sides at once, run with -race
+func TestConcurrentPokes(t *testing.T) {
+	a, b := testPair(t)
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	big := strings.Repeat("A", 3000)
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 10; i++ {
+		for _, pair := range [][2]*Ames{{a, b}, {b, a}} {
+			from, to := pair[0], pair[1]
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				name, _ := noun.BN2patp(to.Ship)
+				c, err := from.Connect(name)
+				if err != nil {
+					errs <- err
+					return
+				}
+				for j := 0; j < 5; j++ {
+					data := noun.MakeNoun(fmt.Sprintf("poke %d %d", i, j))
+					if j == 0 {
+						data = noun.MakeNoun(big)
+					}
+					err := c.Poke(ctx, []string{"ge", "hood"}, "helm-hi", data)
+					if err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(i)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// TestDefaultOptions saves flows under the user cache dir, and breaches
+// without one
+func TestDefaultOptions(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	t.Setenv("HOME", dir)
+	opts := defaultOptions(noun.B(0x10100))
+	name := shipName(noun.B(0x10100)) + ".json"
+	store, ok := opts.Store.(*FileStore)
+	if !ok || opts.Breach || !strings.HasPrefix(store.Path, dir) || filepath.Base(store.Path) != name {
+		t.Errorf("expected %v in %v got %v", name, dir, opts)
+	}
+
+	t.Setenv("XDG_CACHE_HOME", "")
+	t.Setenv("HOME", "")
+	opts = defaultOptions(noun.B(0x10100))
+	if opts.Store != nil || !opts.Breach {
+		t.Errorf("expected %v got %v", Options{Breach: true}, opts)
+	}
+}
@@ -0,0 +1,299 @@
+package ames
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+
+	"github.com/stevelacy/go-urbit/noun"
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+// Dump is a captured packet read as far as the Dumper's keys allow
+type Dump struct {
+	Capture
+	Protocol  Protocol
+	Kind      string // shut, open or scry
+	From      string
+	To        string
+	Relayed   *net.UDPAddr // the origin a galaxy added when relaying
+	Decrypted bool
+	Bone      int // the sender's bone
+	Num       int
+	Fragment  int // -1 for the ack of a whole message
+	Fragments int
+	Ack       bool
+	Nack      bool
+	// Message is the plea, boon or, for a naxplanation, the *NackError
+	// once its last fragment is read, or the noun if it is none of them
+	Message  any
+	Explains int   // the message num a naxplanation is for
+	Err      error // why the packet couldn't be read further
+}
+
+// Dumper reads captured packets for people, decrypting those between
+// us and the peers it has keys for and putting messages back together.
+// It is not safe for concurrent use
+type Dumper struct {
+	id      *Identity
+	keys    map[string]dumpKeys
+	partial map[string]map[int]noun.Noun // fragments by sender, bone and num
+}
+
+type dumpKeys struct {
+	symKey []byte
+	life   int64
+}
+
+// NewDumper decrypts with our identity, or only reads headers if it is
+// nil
+func NewDumper(id *Identity) *Dumper {
+	return &Dumper{
+		id:      id,
+		keys:    make(map[string]dumpKeys),
+		partial: make(map[string]map[int]noun.Noun),
+	}
+}
+
+// AddPeer gives the dumper a peer's keys, as a lookup returns them.
+// The keys of comets are learned from their open packets
+func (d *Dumper) AddPeer(ship string, res LookupResponse) error {
+	if d.id == nil {
+		return errors.New("dump: no identity to decrypt with")
+	}
+	d.keys[ship] = dumpKeys{urcrypt.UrcryptEdShar(keyFromHex(res.EncryptionKey), d.id.CryptKey), res.Life}
+	return nil
+}
+
+// peerKeys finds the keys for a packet between us and a peer
+func (d *Dumper) peerKeys(from, to *big.Int) (dumpKeys, error) {
+	if d.id == nil {
+		return dumpKeys{}, errors.New("dump: no identity to decrypt with")
+	}
+	peer := from
+	if from.Cmp(d.id.Ship) == 0 {
+		peer = to
+	} else if to.Cmp(d.id.Ship) != 0 {
+		return dumpKeys{}, errors.New("dump: packet isn't ours")
+	}
+	k, ok := d.keys[shipName(peer)]
+	if !ok {
+		return dumpKeys{}, fmt.Errorf("dump: no keys for %s", shipName(peer))
+	}
+	return k, nil
+}
+
+// Dump reads one captured packet
+func (d *Dumper) Dump(c Capture) Dump {
+	dump := Dump{Capture: c, Fragment: -1}
+	v, err := PacketVersion(c.Data)
+	if err != nil {
+		dump.Err = err
+		return dump
+	}
+	dump.Protocol = v
+	switch {
+	case v != ProtocolAmes:
+		dump.Err = errors.New("error: version invalid")
+	case !isAmesPacket(c.Data):
+		dump.Kind = "scry"
+	default:
+		d.shut(&dump)
+	}
+	return dump
+}
+
+func (d *Dumper) shut(dump *Dump) {
+	dump.Kind = "shut"
+	header, body, err := decodeHeader(dump.Data)
+	if err != nil {
+		dump.Err = err
+		return
+	}
+	from, to, fromTick, toTick, content := decodeBody(header, body)
+	dump.From, dump.To = shipName(from), shipName(to)
+	if header.relayed {
+		dump.Relayed = DecodeLane(header.origin)
+	}
+
+	if _, known := d.keys[dump.From]; rank(from) == cometRank && !known {
+		if d.open(dump, from, to, content) {
+			return
+		}
+	}
+
+	k, err := d.peerKeys(from, to)
+	if err != nil {
+		dump.Err = err
+		return
+	}
+	fromLife, toLife := k.life, d.id.Life
+	if from.Cmp(d.id.Ship) == 0 {
+		fromLife, toLife = toLife, fromLife
+	}
+	pat, err := DecodeShutPacket(content, k.symKey, from, to, fromTick, toTick, fromLife, toLife)
+	if err != nil {
+		dump.Err = err
+		return
+	}
+	dump.Decrypted = true
+	bone, num, tag, meat, err := readShut(pat)
+	if err != nil {
+		dump.Err = err
+		return
+	}
+	d.meat(dump, from, bone, num, tag == ackTag, meat)
+}
+
+// readShut splits a shut packet into bone, num, tag and meat
+func readShut(pat noun.Noun) (int, int, int, noun.Noun, error) {
+	bone, err1 := noun.AssertAtom(noun.Head(pat))
+	num, err2 := noun.AssertAtom(noun.Head(noun.Tail(pat)))
+	tag, err3 := noun.AssertAtom(noun.Head(noun.Tail(noun.Tail(pat))))
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, 0, 0, noun.MakeNoun(0), errors.New("error: not a shut packet")
+	}
+	return int(bone.Value.Int64()), int(num.Value.Int64()), int(tag.Value.Int64()), noun.Tail(noun.Tail(noun.Tail(pat))), nil
+}
+
+// open reads a comet's attestation, learning its keys if it is talking
+// to us. A comet's shut packets aren't open packets, so false means
+// read it as one of those
+func (d *Dumper) open(dump *Dump, from, to, content *big.Int) bool {
+	p, err := DecodeOpenPacket(content)
+	if err != nil {
+		return false
+	}
+	dump.Kind = "open"
+	if shaf(noun.StringToCord("bfig").Value, p.PublicKey).Cmp(from) != 0 {
+		dump.Err = errors.New("open packet: comet name doesn't match its keys")
+		return true
+	}
+	if d.id != nil && to.Cmp(d.id.Ship) == 0 {
+		_, cry, _ := passKeys(p.PublicKey)
+		d.keys[dump.From] = dumpKeys{urcrypt.UrcryptEdShar(cry, d.id.CryptKey), p.SenderLife}
+	}
+	return true
+}
+
+// meat reads an ack or a fragment, decoding the message once all its
+// fragments have been seen
+func (d *Dumper) meat(dump *Dump, from *big.Int, bone, num int, ack bool, meat noun.Noun) {
+	dump.Bone, dump.Num = bone, num
+	if ack {
+		dump.Ack = true
+		final, err := noun.AssertAtom(noun.Head(meat))
+		if err != nil {
+			dump.Err = err
+			return
+		}
+		if final.Value.Sign() == 0 {
+			fun, _ := noun.AssertAtom(noun.Tail(meat))
+			dump.Fragment = int(fun.Value.Int64())
+			return
+		}
+		dump.Fragment = -1
+		ok, _ := noun.AssertAtom(noun.Head(noun.Tail(meat)))
+		dump.Nack = ok.Value != nil && ok.Value.Sign() != 0
+		return
+	}
+
+	total, fun, err := fragmentMeta(meat)
+	if err != nil {
+		dump.Err = err
+		return
+	}
+	dump.Fragments, dump.Fragment = total, fun
+	key := fmt.Sprintf("%s/%d/%d", dump.From, bone, num)
+	frags, ok := d.partial[key]
+	if !ok {
+		frags = make(map[int]noun.Noun)
+		d.partial[key] = frags
+	}
+	frags[fun] = meat
+	if len(frags) < total {
+		return
+	}
+	delete(d.partial, key)
+	msg, err := JoinMessage(sortFragments(frags))
+	if err != nil {
+		dump.Err = err
+		return
+	}
+
+	// naxplanations are sent on the nacked flow's bone mixed with 2
+	if bone&2 != 0 {
+		num, nerr, err := DecodeNaxplanation(msg)
+		if err == nil {
+			dump.Message, dump.Explains = nerr, num
+			return
+		}
+	}
+	if plea, err := DecodePlea(msg); err == nil {
+		dump.Message = plea
+	} else if boon, err := DecodeBoon(msg); err == nil {
+		dump.Message = boon
+	} else {
+		dump.Message = msg
+	}
+}
+
+// String is one line for amesdump
+func (d Dump) String() string {
+	dir := "<"
+	if d.Out {
+		dir = ">"
+	}
+	lane := "-"
+	if d.Lane != nil {
+		lane = d.Lane.String()
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s %s %s", d.Time.Format("15:04:05.000000"), dir, lane, d.Protocol, d.Kind)
+	if d.From != "" {
+		fmt.Fprintf(&b, " %s -> %s", d.From, d.To)
+	}
+	if d.Relayed != nil {
+		fmt.Fprintf(&b, " via %s", d.Relayed)
+	}
+	switch {
+	case !d.Decrypted:
+	case d.Ack && d.Fragment == -1 && d.Nack:
+		fmt.Fprintf(&b, " bone %d num %d nack", d.Bone, d.Num)
+	case d.Ack && d.Fragment == -1:
+		fmt.Fprintf(&b, " bone %d num %d ack", d.Bone, d.Num)
+	case d.Ack:
+		fmt.Fprintf(&b, " bone %d num %d ack fragment %d", d.Bone, d.Num, d.Fragment)
+	default:
+		fmt.Fprintf(&b, " bone %d num %d fragment %d/%d", d.Bone, d.Num, d.Fragment, d.Fragments)
+	}
+	if d.Message != nil {
+		b.WriteString(" " + describeMessage(d.Message, d.Explains))
+	}
+	if d.Err != nil {
+		fmt.Fprintf(&b, " (%v)", d.Err)
+	}
+	return b.String()
+}
+
+func describeMessage(m any, explains int) string {
+	switch m := m.(type) {
+	case Poke:
+		return fmt.Sprintf("poke %s %s %v", m.App, m.Mark, m.Data)
+	case Watch:
+		return fmt.Sprintf("watch %s /%s", m.App, strings.Join(m.Path, "/"))
+	case Leave:
+		return "leave " + m.App
+	case Cork:
+		return "cork"
+	case Fact:
+		return fmt.Sprintf("fact %s %v", m.Mark, m.Data)
+	case Kick:
+		return "kick"
+	case *NackError:
+		return fmt.Sprintf("naxplanation of %d: %v", explains, m)
+	}
+	return fmt.Sprintf("message %v", m)
+}
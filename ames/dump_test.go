@@ -0,0 +1,114 @@
+package ames
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+// recordedPair is hubPair with options, such as a Recorder, for the
+// first ship
+func recordedPair(t *testing.T, opts Options) (*Ames, *Ames) {
+	hub := NewHub(HubOptions{})
+	opts.Transport = hub.Listen()
+	a, err := newAmes(noun.B(0x10100), 1, [32]byte{}, [32]byte{}, nil, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newAmes(noun.B(0x10200), 1, [32]byte{}, [32]byte{}, nil, Options{Transport: hub.Listen()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	introduce(a, b)
+	introduce(b, a)
+	return a, b
+}
+
+func TestDump(t *testing.T) {
+	var buf bytes.Buffer
+	a, b := recordedPair(t, Options{Recorder: NewCaptureWriter(&buf)})
+	c, err := a.Connect(shipName(b.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	err = c.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("hi"))
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Close(context.Background())
+	b.Close(context.Background())
+
+	d := NewDumper(&Identity{Ship: a.Ship, Life: a.Life})
+	d.keys[shipName(b.Ship)] = dumpKeys{[]byte("0123456789abcdef0123456789abcdef"), b.Life}
+	r := NewCaptureReader(&buf)
+	var poked, acked bool
+	for {
+		cap, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		dump := d.Dump(cap)
+		if dump.Err != nil || !dump.Decrypted || dump.Protocol != ProtocolAmes {
+			t.Errorf("expected a decrypted packet got %v", dump)
+		}
+		if p, ok := dump.Message.(Poke); ok && dump.Out && p.App == "hood" && p.Mark == "helm-hi" {
+			poked = true
+		}
+		if dump.Ack && dump.Fragment == -1 && !dump.Nack && !dump.Out && dump.From == shipName(b.Ship) {
+			acked = true
+		}
+	}
+	if !poked || !acked {
+		t.Errorf("expected the poke and its ack got poke %v ack %v", poked, acked)
+	}
+}
+
+func TestDumpWithoutKeys(t *testing.T) {
+	a, b := recordedPair(t, Options{})
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+	c, _ := a.Connect(shipName(b.Ship))
+	pkts, err := c.CreateMessage([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dump := NewDumper(nil).Dump(Capture{Out: true, Data: pkts[0]})
+	if dump.Kind != "shut" || dump.From != shipName(a.Ship) || dump.To != shipName(b.Ship) || dump.Decrypted || dump.Err == nil {
+		t.Errorf("expected only the header read got %v", dump)
+	}
+	dump = NewDumper(nil).Dump(Capture{Data: []byte{1}})
+	if dump.Err == nil {
+		t.Errorf("expected a short packet to fail")
+	}
+}
+
+func TestDumperAddPeer(t *testing.T) {
+	x, _ := GenerateIdentity(noun.B(0x10100), 1)
+	y, _ := GenerateIdentity(noun.B(0x10200), 2)
+	pub := urcrypt.UrcryptEdPuck(y.CryptKey)
+	d := NewDumper(x)
+	err := d.AddPeer(shipName(y.Ship), LookupResponse{EncryptionKey: noun.LittleToBig(pub[:]).Text(16), Life: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both sides of a flow share a key
+	xPub := urcrypt.UrcryptEdPuck(x.CryptKey)
+	expected := urcrypt.UrcryptEdShar(xPub, y.CryptKey)
+	if k := d.keys[shipName(y.Ship)]; !bytes.Equal(k.symKey, expected) || k.life != 2 {
+		t.Errorf("expected %x got %x", expected, k.symKey)
+	}
+	if err := NewDumper(nil).AddPeer(shipName(y.Ship), LookupResponse{}); err == nil {
+		t.Errorf("expected adding a peer without an identity to fail")
+	}
+}
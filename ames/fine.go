@@ -0,0 +1,378 @@
+package ames
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+// fine is the remote scry protocol. A request names a path and a
+// fragment, each response carries one signed fragment of the value
+
+const fineFragmentSize = 1024 // bytes
+
+// maxScryFragments bounds the size of a value we scry, 128MiB, so a
+// response claiming billions of fragments isn't believed
+const maxScryFragments = 1 << 17
+
+// scryRetryInterval is how often missing fragments are requested again
+var scryRetryInterval = time.Second
+
+var ErrScryEmpty = errors.New("scry: no value at path")
+
+// FineRequest asks for one fragment (from 1) of a path
+type FineRequest struct {
+	Path      string
+	Fragment  int
+	Signature [64]byte
+}
+
+// FineResponse is one signed fragment of a remote scry value
+type FineResponse struct {
+	Path      string
+	Fragment  int
+	Fragments int
+	Signature [64]byte
+	Data      []byte
+}
+
+type scryRequest struct {
+	mut     sync.Mutex
+	path    string
+	num     int // total fragments, zero until the first response
+	frags   map[int][]byte
+	waiters int
+	done    chan struct{}
+	res     noun.Noun
+	err     error
+}
+
+// Scry reads path from ship's published namespace over remote scry
+func (a *Ames) Scry(ctx context.Context, ship string, path []string) (noun.Noun, error) {
+	p, err := noun.Patp2bn(ship)
+	if err != nil {
+		return noun.MakeNoun(0), err
+	}
+	peer, err := a.GetPeer(p)
+	if err != nil {
+		return noun.MakeNoun(0), err
+	}
+	spur := "/" + strings.Join(path, "/")
+	key := scryID(p, spur)
+
+	// concurrent reads of the same path share a request
+	a.scryMut.Lock()
+	if a.scries == nil {
+		a.scries = make(map[string]*scryRequest)
+	}
+	req, ok := a.scries[key]
+	if !ok {
+		req = &scryRequest{
+			path:  spur,
+			frags: make(map[int][]byte),
+			done:  make(chan struct{}),
+		}
+		a.scries[key] = req
+	}
+	req.waiters++
+	a.scryMut.Unlock()
+
+	defer func() {
+		a.scryMut.Lock()
+		req.waiters--
+		if req.waiters == 0 && a.scries[key] == req {
+			delete(a.scries, key)
+		}
+		a.scryMut.Unlock()
+	}()
+
+	ticker := time.NewTicker(scryRetryInterval)
+	defer ticker.Stop()
+	for {
+		err = a.requestMissing(peer, req)
+		if err != nil {
+			return noun.MakeNoun(0), err
+		}
+		select {
+		case <-req.done:
+			return req.res, req.err
+		case <-ctx.Done():
+			return noun.MakeNoun(0), ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// requestMissing asks for the first fragment until the size is known,
+// then every fragment not yet heard
+func (a *Ames) requestMissing(peer *Peer, req *scryRequest) error {
+	req.mut.Lock()
+	missing := []int{}
+	if req.num == 0 {
+		missing = append(missing, 1)
+	}
+	for fra := 1; fra <= req.num; fra++ {
+		if _, ok := req.frags[fra]; !ok {
+			missing = append(missing, fra)
+		}
+	}
+	req.mut.Unlock()
+
+	for _, fra := range missing {
+		_, authKey, life := a.ourKeys()
+		pkt := EncodeFineRequest(a.Ship, peer.ship, life, peer.Life(), FineRequest{Path: req.path, Fragment: fra}, authKey)
+		err := a.sendTo(peer, pkt)
+		// asked again on the next tick once the relay is found
+		if err != nil && !errors.Is(err, ErrLookingUp) {
+			return err
+		}
+	}
+	return nil
+}
+
+// onFineResponse verifies and stores a response fragment, finishing
+// the request once every fragment has arrived
+func (a *Ames) onFineResponse(pkt []byte, src *net.UDPAddr) error {
+	from, _, request, content, err := DecodeFinePacket(pkt)
+	if err != nil {
+		return err
+	}
+	origin, _ := PacketOrigin(pkt)
+	// we don't publish anything
+	if request {
+		return nil
+	}
+	res, err := DecodeFineResponse(content)
+	if err != nil {
+		return err
+	}
+	peer, err := a.sender(from)
+	if err != nil {
+		return err
+	}
+	if !VerifyFineResponse(res, peer.signingKey()) {
+		return errors.New("scry: invalid signature")
+	}
+	peer.learnLane(src, origin, a.clock.Now())
+
+	key := scryID(from, res.Path)
+	a.scryMut.Lock()
+	req, ok := a.scries[key]
+	a.scryMut.Unlock()
+	if !ok {
+		return nil
+	}
+
+	req.mut.Lock()
+	// the scry fails rather than asking for every fragment
+	if res.Fragments > maxScryFragments {
+		err := fmt.Errorf("scry: %d fragments is more than the %d we accept", res.Fragments, maxScryFragments)
+		select {
+		case <-req.done:
+		default:
+			req.err = err
+			close(req.done)
+		}
+		req.mut.Unlock()
+		return err
+	}
+	first := req.num == 0
+	if first {
+		req.num = res.Fragments
+	}
+	if res.Fragments != req.num || res.Fragment < 1 || res.Fragment > req.num {
+		req.mut.Unlock()
+		return errors.New("scry: fragment out of range")
+	}
+	_, heard := req.frags[res.Fragment]
+	complete := len(req.frags) == req.num
+	if !heard && !complete {
+		req.frags[res.Fragment] = res.Data
+		if len(req.frags) == req.num {
+			req.res, req.err = joinScry(req.frags, req.num)
+			close(req.done)
+		}
+	}
+	req.mut.Unlock()
+
+	// ask for the rest straight away rather than on the next tick
+	if first && res.Fragments > 1 {
+		return a.requestMissing(peer, req)
+	}
+	return nil
+}
+
+func scryID(ship *big.Int, path string) string {
+	return ship.Text(16) + path
+}
+
+// joinScry cues the fragments into the (unit cask) published at the
+// path, returning the noun of the cask
+func joinScry(frags map[int][]byte, num int) (noun.Noun, error) {
+	msg := noun.B(0)
+	for fra := 1; fra <= num; fra++ {
+		dat := make([]byte, len(frags[fra]))
+		copy(dat, frags[fra])
+		msg = noun.CatLen(msg, noun.LittleToBig(dat), uint((fra-1)*fineFragmentSize*8))
+	}
+	res, err := noun.SafeCue(msg)
+	if err != nil {
+		return noun.MakeNoun(0), err
+	}
+	if _, ok := res.(noun.Atom); ok {
+		return noun.MakeNoun(0), ErrScryEmpty
+	}
+	// [~ mark noun]
+	return noun.Tail(noun.Tail(res)), nil
+}
+
+// EncodeFineRequest builds a request packet signed with our auth seed
+func EncodeFineRequest(from, to *big.Int, fromLife, toLife int64, req FineRequest, authSeed [32]byte) []byte {
+	peep := encodePeep(req.Path, req.Fragment)
+	sig := urcrypt.UrcryptEdSign(peep, authSeed)
+
+	wail := append(sig[:], peep...)
+	return encodeFinePacket(from, to, fromLife, toLife, true, wail)
+}
+
+// EncodeFineResponse builds the response packet for one fragment
+func EncodeFineResponse(from, to *big.Int, fromLife, toLife int64, res FineResponse, authSeed [32]byte) []byte {
+	sig := urcrypt.UrcryptEdSign(fineSigMessage(res.Path, res.Fragment, res.Data), authSeed)
+
+	purr := encodePeep(res.Path, res.Fragment)
+	purr = append(purr, sig[:]...)
+	purr = append(purr, uint32Bytes(uint32(res.Fragments), 4)...)
+	purr = append(purr, res.Data...)
+	return encodeFinePacket(from, to, fromLife, toLife, false, purr)
+}
+
+// DecodeFinePacket returns sender, receiver, whether it is a request
+// and the content of a remote scry packet
+func DecodeFinePacket(pkt []byte) (*big.Int, *big.Int, bool, *big.Int, error) {
+	header, body, err := decodeHeader(pkt)
+	if err != nil {
+		return noun.B(0), noun.B(0), false, noun.B(0), err
+	}
+	if header.isAmes || header.version != 0 {
+		return noun.B(0), noun.B(0), false, noun.B(0), errors.New("error: not a scry packet")
+	}
+	from, to, _, _, content := decodeBody(header, body)
+	return from, to, header.request, content, nil
+}
+
+// DecodeFineRequest is the reverse of EncodeFineRequest's content
+func DecodeFineRequest(content *big.Int) (FineRequest, error) {
+	b := noun.BigToLittle(content)
+	sig, b := takeBytes(b, 64)
+	path, fra, _, err := decodePeep(b)
+	if err != nil {
+		return FineRequest{}, err
+	}
+	req := FineRequest{Path: path, Fragment: fra}
+	copy(req.Signature[:], sig)
+	return req, nil
+}
+
+// DecodeFineResponse is the reverse of EncodeFineResponse's content
+func DecodeFineResponse(content *big.Int) (FineResponse, error) {
+	b := noun.BigToLittle(content)
+	path, fra, b, err := decodePeep(b)
+	if err != nil {
+		return FineResponse{}, err
+	}
+	sig, b := takeBytes(b, 64)
+	num, b := takeBytes(b, 4)
+	res := FineResponse{
+		Path:      path,
+		Fragment:  fra,
+		Fragments: int(bytesUint32(num)),
+		Data:      b,
+	}
+	copy(res.Signature[:], sig)
+	return res, nil
+}
+
+// VerifyFineResponse checks the fragment was signed by the auth key
+func VerifyFineResponse(res FineResponse, authKey [32]byte) bool {
+	return urcrypt.UrcryptEdVeri(fineSigMessage(res.Path, res.Fragment, res.Data), res.Signature, authKey)
+}
+
+func encodeFinePacket(from, to *big.Int, fromLife, toLife int64, request bool, content []byte) []byte {
+	c := make([]byte, len(content))
+	copy(c, content)
+	body, senderRank, receiverRank := encodeBody(
+		noun.Atom{Value: from},
+		noun.Atom{Value: to},
+		noun.B(fromLife%16),
+		noun.B(toLife%16),
+		noun.LittleToBig(c),
+	)
+	return encodeHeader(body, request, false, senderRank, receiverRank)
+}
+
+// encodePeep is the fragment num (4 bytes), path length (2 bytes) and path
+func encodePeep(path string, fra int) []byte {
+	b := uint32Bytes(uint32(fra), 4)
+	b = append(b, uint32Bytes(uint32(len(path)), 2)...)
+	return append(b, []byte(path)...)
+}
+
+func decodePeep(b []byte) (string, int, []byte, error) {
+	fra, b := takeBytes(b, 4)
+	l, b := takeBytes(b, 2)
+	length := int(bytesUint32(l))
+	if length > len(b) {
+		return "", 0, b, errors.New("scry: path length out of range")
+	}
+	return string(b[:length]), int(bytesUint32(fra)), b[length:], nil
+}
+
+// fineSigMessage is what the host signs, (jam [path fra dat])
+func fineSigMessage(path string, fra int, dat []byte) []byte {
+	d := make([]byte, len(dat))
+	copy(d, dat)
+	spur := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	n := noun.MakeNoun([]interface{}{spur, fra, noun.LittleToBig(d)})
+	return noun.BigToLittle(noun.Jam(n))
+}
+
+// takeBytes splits off n bytes, padding with zeros if b is short as
+// atoms drop their trailing zero bytes
+func takeBytes(b []byte, n int) ([]byte, []byte) {
+	out := make([]byte, n)
+	if len(b) < n {
+		copy(out, b)
+		return out, []byte{}
+	}
+	copy(out, b[:n])
+	return out, b[n:]
+}
+
+func uint32Bytes(v uint32, n int) []byte {
+	b := make([]byte, n)
+	for i := 0; i < n; i++ {
+		b[i] = byte(v >> (8 * i))
+	}
+	return b
+}
+
+func bytesUint32(b []byte) uint32 {
+	var v uint32
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint32(b[i])
+	}
+	return v
+}
+
+// isAmesPacket checks the header bit that separates ames from scry packets
+func isAmesPacket(pkt []byte) bool {
+	return len(pkt) > 0 && pkt[0]>>3&0b1 == 0
+}
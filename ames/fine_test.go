@@ -0,0 +1,182 @@
+package ames
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+func TestFineRequest(t *testing.T) {
+	seed := [32]byte{3}
+	req := FineRequest{Path: "/c/x/1/base/sys/kelvin", Fragment: 2}
+	pkt := EncodeFineRequest(noun.B(0x10100), noun.B(0x7e7100010100), 1, 2, req, seed)
+
+	if isAmesPacket(pkt) {
+		t.Errorf("expected a scry packet")
+	}
+	from, to, request, content, err := DecodeFinePacket(pkt)
+	if err != nil {
+		t.Error(err)
+	}
+	if noun.B(0x10100).Cmp(from) != 0 {
+		t.Errorf("expected %v got %v", noun.B(0x10100), from)
+	}
+	if noun.B(0x7e7100010100).Cmp(to) != 0 {
+		t.Errorf("expected %v got %v", noun.B(0x7e7100010100), to)
+	}
+	if !request {
+		t.Errorf("expected %v got %v", true, request)
+	}
+	r1, err := DecodeFineRequest(content)
+	if err != nil {
+		t.Error(err)
+	}
+	if r1.Path != req.Path || r1.Fragment != req.Fragment {
+		t.Errorf("expected %v got %v", req, r1)
+	}
+	if !urcrypt.UrcryptEdVeri(encodePeep(req.Path, req.Fragment), r1.Signature, urcrypt.UrcryptEdPuck(seed)) {
+		t.Errorf("expected a valid request signature")
+	}
+}
+
+func TestFineResponse(t *testing.T) {
+	seed := [32]byte{3}
+	res := FineResponse{Path: "/g/x/0/hood//kiln", Fragment: 1, Fragments: 3, Data: []byte{1, 2, 3}}
+	pkt := EncodeFineResponse(noun.B(0x10100), noun.B(0x7e7100010100), 1, 2, res, seed)
+
+	_, _, request, content, err := DecodeFinePacket(pkt)
+	if err != nil {
+		t.Error(err)
+	}
+	if request {
+		t.Errorf("expected %v got %v", false, request)
+	}
+	r1, err := DecodeFineResponse(content)
+	if err != nil {
+		t.Error(err)
+	}
+	if r1.Path != res.Path || r1.Fragment != 1 || r1.Fragments != 3 || !reflect.DeepEqual(r1.Data, res.Data) {
+		t.Errorf("expected %v got %v", res, r1)
+	}
+	if !VerifyFineResponse(r1, urcrypt.UrcryptEdPuck(seed)) {
+		t.Errorf("expected a valid signature")
+	}
+	if VerifyFineResponse(r1, urcrypt.UrcryptEdPuck([32]byte{4})) {
+		t.Errorf("expected an invalid signature from another key")
+	}
+
+	// an ames packet is not a scry packet
+	_, _, _, _, err = DecodeFinePacket([]byte{128, 28, 112, 182, 33, 0, 1, 1, 0, 0, 1, 1, 0, 113, 126, 0, 0, 251, 177, 66, 74, 134, 147, 242, 188, 119, 57, 37, 27, 132, 153, 69, 253, 34, 0, 174, 98, 110, 181, 25, 144, 121, 192, 44, 232, 136, 22, 223, 146, 232, 23, 9, 200, 94, 235, 235, 169, 110, 64, 44, 233, 30, 17, 20, 94, 212, 254, 76, 106})
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+// splitScry cuts a jammed value into 1 indexed fine fragments
+func splitScry(n noun.Noun) map[int][]byte {
+	b := noun.BigToLittle(noun.Jam(n))
+	frags := make(map[int][]byte)
+	for i := 0; i*fineFragmentSize < len(b); i++ {
+		end := (i + 1) * fineFragmentSize
+		if end > len(b) {
+			end = len(b)
+		}
+		frags[i+1] = b[i*fineFragmentSize : end]
+	}
+	return frags
+}
+
+func TestJoinScry(t *testing.T) {
+	value := noun.MakeNoun(noun.B(0).Exp(noun.B(3), noun.B(10000), nil))
+	frags := splitScry(noun.MakeNoun([]interface{}{0, "noun", value}))
+	if len(frags) != 2 {
+		t.Errorf("expected %v got %v", 2, len(frags))
+	}
+	r1, err := joinScry(frags, len(frags))
+	if err != nil {
+		t.Error(err)
+	}
+	if r1.String() != value.String() {
+		t.Errorf("expected %v got %v", value, r1)
+	}
+
+	_, err = joinScry(splitScry(noun.MakeNoun(0)), 1)
+	if err != ErrScryEmpty {
+		t.Errorf("expected %v got %v", ErrScryEmpty, err)
+	}
+
+	// a jam an unbounded cue never finishes
+	done := make(chan error, 1)
+	go func() {
+		_, err := joinScry(map[int][]byte{1: {0x01, 0x7f, 0xbc, 0x89}}, 1)
+		done <- err
+	}()
+	select {
+	case err = <-done:
+		if err == nil {
+			t.Errorf("expected an error got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("joinScry did not return")
+	}
+}
+
+func TestOnFineResponse(t *testing.T) {
+	seed := [32]byte{3}
+	host := noun.B(0x7e7100010100)
+	a := &Ames{Ship: noun.B(0x10100), Peers: make(map[string]*Peer), clock: newFakeClock()}
+	patp, _ := noun.BN2patp(host)
+	a.Peers[patp] = &Peer{ship: host, authKey: urcrypt.UrcryptEdPuck(seed)}
+
+	req := &scryRequest{path: "/g/x/0/hood//kiln", frags: make(map[int][]byte), done: make(chan struct{})}
+	a.scries = map[string]*scryRequest{scryID(host, req.path): req}
+
+	frags := splitScry(noun.MakeNoun([]interface{}{0, "noun", "hi"}))
+	res := FineResponse{Path: req.path, Fragment: 1, Fragments: 1, Data: frags[1]}
+
+	// a response signed by anyone else is rejected
+	forged := EncodeFineResponse(host, a.Ship, 1, 1, res, [32]byte{4})
+	if a.onFineResponse(forged, nil) == nil {
+		t.Errorf("expected an error")
+	}
+
+	err := a.onFineResponse(EncodeFineResponse(host, a.Ship, 1, 1, res, seed), nil)
+	if err != nil {
+		t.Error(err)
+	}
+	<-req.done
+	if req.err != nil || req.res.String() != noun.MakeNoun("hi").String() {
+		t.Errorf("expected %v got %v %v", noun.MakeNoun("hi"), req.res, req.err)
+	}
+
+	// a value too big to fetch is refused before anything is requested
+	big := &scryRequest{path: "/g/x/0/hood//big", frags: make(map[int][]byte), done: make(chan struct{})}
+	a.scries[scryID(host, big.path)] = big
+	res = FineResponse{Path: big.path, Fragment: 1, Fragments: 0xffffffff, Data: frags[1]}
+	err = a.onFineResponse(EncodeFineResponse(host, a.Ship, 1, 1, res, seed), nil)
+	<-big.done
+	if err == nil || big.err != err || big.num != 0 {
+		t.Errorf("expected %v got %v with %v fragments", err, big.err, big.num)
+	}
+}
+
+// TestScryLookingUp keeps asking while the peer's relay is found
+func TestScryLookingUp(t *testing.T) {
+	a, b := testPairOptions(t, Options{Galaxies: map[string]string{"~zod": "127.0.0.1:1"}})
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+	peer, _ := a.GetPeer(b.Ship)
+	peer.laneMut.Lock()
+	peer.relay = nil
+	peer.laneMut.Unlock()
+
+	req := &scryRequest{path: "/g/x/0/hood//kiln", frags: make(map[int][]byte), done: make(chan struct{})}
+	err := a.requestMissing(peer, req)
+	if err != nil {
+		t.Errorf("expected %v got %v", nil, err)
+	}
+}
@@ -0,0 +1,149 @@
+package ames
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// hubQueue is how many packets a hub transport holds unread before
+// dropping more, as a full socket buffer does
+const hubQueue = 1024
+
+// HubOptions are the network conditions of a Hub, the zero value is a
+// perfect network
+type HubOptions struct {
+	Loss      float64 // fraction of packets dropped
+	Duplicate float64 // fraction of packets delivered twice
+	// Reorder is the fraction of packets held back by an extra Latency,
+	// or a millisecond without one, so the next ones overtake them
+	Reorder float64
+	Latency time.Duration
+	// Seed makes the drops, duplicates and reorders repeatable
+	Seed int64
+}
+
+// Hub is an in-memory network joining transports in one process, so
+// ships can be tested offline
+type Hub struct {
+	mut   sync.Mutex
+	opts  HubOptions
+	rand  *rand.Rand
+	conns map[string]*hubTransport
+	port  int
+}
+
+func NewHub(opts HubOptions) *Hub {
+	return &Hub{
+		opts:  opts,
+		rand:  rand.New(rand.NewSource(opts.Seed)),
+		conns: make(map[string]*hubTransport),
+		port:  40000,
+	}
+}
+
+// SetOptions changes the network conditions for packets sent from now
+func (h *Hub) SetOptions(opts HubOptions) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.opts = opts
+}
+
+// Listen returns a transport on the next free port of 127.0.0.1
+func (h *Hub) Listen() Transport {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.port++
+	t := &hubTransport{
+		hub:    h,
+		addr:   &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: h.port},
+		in:     make(chan hubPacket, hubQueue),
+		closed: make(chan struct{}),
+	}
+	h.conns[t.addr.String()] = t
+	return t
+}
+
+type hubPacket struct {
+	b    []byte
+	from *net.UDPAddr
+}
+
+// send delivers a packet under the hub's conditions. Like UDP nothing
+// is reported when it is lost or nobody is listening
+func (h *Hub) send(b []byte, from, to *net.UDPAddr) {
+	h.mut.Lock()
+	dst, ok := h.conns[to.String()]
+	if !ok || h.rand.Float64() < h.opts.Loss {
+		h.mut.Unlock()
+		return
+	}
+	copies := 1
+	if h.rand.Float64() < h.opts.Duplicate {
+		copies = 2
+	}
+	delay := h.opts.Latency
+	if h.rand.Float64() < h.opts.Reorder {
+		delay += max(h.opts.Latency, time.Millisecond)
+	}
+	h.mut.Unlock()
+
+	p := hubPacket{b: append([]byte{}, b...), from: from}
+	for i := 0; i < copies; i++ {
+		if delay == 0 {
+			dst.deliver(p)
+			continue
+		}
+		time.AfterFunc(delay, func() { dst.deliver(p) })
+	}
+}
+
+type hubTransport struct {
+	hub       *Hub
+	addr      *net.UDPAddr
+	in        chan hubPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (t *hubTransport) deliver(p hubPacket) {
+	select {
+	case <-t.closed:
+	case t.in <- p:
+	default:
+	}
+}
+
+func (t *hubTransport) ReadFrom(b []byte) (int, *net.UDPAddr, error) {
+	select {
+	case p := <-t.in:
+		return copy(b, p.b), p.from, nil
+	case <-t.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (t *hubTransport) WriteTo(b []byte, addr *net.UDPAddr) (int, error) {
+	select {
+	case <-t.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	t.hub.send(b, t.addr, addr)
+	return len(b), nil
+}
+
+func (t *hubTransport) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		t.hub.mut.Lock()
+		delete(t.hub.conns, t.addr.String())
+		t.hub.mut.Unlock()
+	})
+	return nil
+}
+
+func (t *hubTransport) LocalAddr() *net.UDPAddr {
+	return t.addr
+}
@@ -0,0 +1,132 @@
+package ames
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+// hubPair is testPair on an in-memory network
+func hubPair(t *testing.T, hub *Hub) (*Ames, *Ames) {
+	a, err := newAmes(noun.B(0x10100), 1, [32]byte{}, [32]byte{}, nil, Options{Transport: hub.Listen()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newAmes(noun.B(0x10200), 1, [32]byte{}, [32]byte{}, nil, Options{Transport: hub.Listen()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	introduce(a, b)
+	introduce(b, a)
+	return a, b
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub(HubOptions{})
+	x := hub.Listen()
+	y := hub.Listen()
+	// one reader, so a read that timed out doesn't eat the next packet
+	got := make(chan string, 8)
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, _, err := y.ReadFrom(buf)
+			if err != nil {
+				close(got)
+				return
+			}
+			got <- string(buf[:n])
+		}
+	}()
+	read := func() string {
+		select {
+		case s := <-got:
+			return s
+		case <-time.After(100 * time.Millisecond):
+			return ""
+		}
+	}
+
+	x.WriteTo([]byte("one"), y.LocalAddr())
+	if r1 := read(); r1 != "one" {
+		t.Errorf("expected %v got %v", "one", r1)
+	}
+
+	hub.SetOptions(HubOptions{Loss: 1})
+	x.WriteTo([]byte("lost"), y.LocalAddr())
+	if r2 := read(); r2 != "" {
+		t.Errorf("expected nothing got %v", r2)
+	}
+
+	hub.SetOptions(HubOptions{Duplicate: 1})
+	x.WriteTo([]byte("two"), y.LocalAddr())
+	if r3, r4 := read(), read(); r3 != "two" || r4 != "two" {
+		t.Errorf("expected %v twice got %v %v", "two", r3, r4)
+	}
+
+	hub.SetOptions(HubOptions{Reorder: 1, Latency: 10 * time.Millisecond})
+	x.WriteTo([]byte("late"), y.LocalAddr())
+	hub.SetOptions(HubOptions{})
+	x.WriteTo([]byte("early"), y.LocalAddr())
+	if r5, r6 := read(), read(); r5 != "early" || r6 != "late" {
+		t.Errorf("expected %v %v got %v %v", "early", "late", r5, r6)
+	}
+
+	y.Close()
+	<-got
+	if _, _, err := y.ReadFrom(make([]byte, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("expected %v got %v", net.ErrClosed, err)
+	}
+	if _, err := x.WriteTo([]byte("gone"), y.LocalAddr()); err != nil {
+		t.Errorf("expected writes to nobody to be dropped got %v", err)
+	}
+}
+
+// TestHubLossy runs pokes both ways over a network that drops,
+// duplicates and reorders packets
+func TestHubLossy(t *testing.T) {
+	hub := NewHub(HubOptions{Loss: 0.1, Duplicate: 0.1, Reorder: 0.2, Latency: time.Millisecond, Seed: 1})
+	a, b := hubPair(t, hub)
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for _, pair := range [][2]*Ames{{a, b}, {b, a}} {
+		from, to := pair[0], pair[1]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := from.Connect(shipName(to.Ship))
+			if err != nil {
+				errs <- err
+				return
+			}
+			for i := 0; i < 5; i++ {
+				data := noun.MakeNoun(fmt.Sprintf("poke %d", i))
+				if i == 0 {
+					data = noun.MakeNoun(strings.Repeat("A", 5000))
+				}
+				err := c.Poke(ctx, []string{"ge", "hood"}, "helm-hi", data)
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
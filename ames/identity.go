@@ -0,0 +1,144 @@
+package ames
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/stevelacy/go-urbit/noun"
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+// Identity is the ship we run as and its networking keys
+type Identity struct {
+	Ship     *big.Int
+	Life     int64
+	CryptKey [32]byte // seed of the encryption key
+	AuthKey  [32]byte // seed of the signing key
+}
+
+// NewKeyfileIdentity parses a network keyfile of any ship, the jammed
+// [ship life ring ~] in any encoding ParseKey reads
+func NewKeyfileIdentity(keyfile string) (*Identity, error) {
+	bSeed, err := ParseKey(keyfile)
+	if err != nil {
+		return nil, err
+	}
+	shp, life, privKey, err := ParseSeed(bSeed)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{
+		Ship:     shp,
+		Life:     life.Int64(),
+		CryptKey: privKey,
+		AuthKey:  parseAuthSeed(bSeed),
+	}, nil
+}
+
+// LoadKeyfile reads a .key network keyfile as downloaded from Bridge
+func LoadKeyfile(path string) (*Identity, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyfileIdentity(string(b))
+}
+
+// GenerateIdentity makes new random keys for ship. The keys only work
+// once they are set on azimuth, or for a moon by its parent
+func GenerateIdentity(ship *big.Int, life int64) (*Identity, error) {
+	id := &Identity{Ship: ship, Life: life}
+	_, err := io.ReadFull(rand.Reader, id.CryptKey[:])
+	if err == nil {
+		_, err = io.ReadFull(rand.Reader, id.AuthKey[:])
+	}
+	if err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// NewMoonIdentity parses the seed printed by |moon
+func NewMoonIdentity(seed string) (*Identity, error) {
+	id, err := NewKeyfileIdentity(seed)
+	if err != nil {
+		return nil, err
+	}
+	if rank(id.Ship) != moonRank {
+		return nil, errors.New("seed is not for a moon")
+	}
+	return id, nil
+}
+
+// NewComet mines a new comet sponsored by star, or by any star if star
+// is nil. A star takes around 65k attempts
+func NewComet(ctx context.Context, star *big.Int) (*Identity, error) {
+	return mineComet(ctx, star, rand.Reader)
+}
+
+func mineComet(ctx context.Context, star *big.Int, r io.Reader) (*Identity, error) {
+	if star != nil && rank(star) > starRank {
+		return nil, errors.New("comets are sponsored by a star or galaxy")
+	}
+	for i := 0; ; i++ {
+		// don't check ctx on every attempt, they are cheap
+		if i%1024 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		id := &Identity{Life: 1}
+		_, err := io.ReadFull(r, id.CryptKey[:])
+		if err == nil {
+			_, err = io.ReadFull(r, id.AuthKey[:])
+		}
+		if err != nil {
+			return nil, err
+		}
+		// a comet is named by the fingerprint of its keys
+		id.Ship = shaf(noun.StringToCord("bfig").Value, id.Pass())
+		if rank(id.Ship) != cometRank {
+			continue
+		}
+		if star != nil && sein(id.Ship).Cmp(star) != 0 {
+			continue
+		}
+		return id, nil
+	}
+}
+
+// Seed is the jammed [ship life ring ~], the atom a keyfile holds
+func (id *Identity) Seed() *big.Int {
+	ring := append([]byte{'B'}, id.AuthKey[:]...)
+	ring = append(ring, id.CryptKey[:]...)
+	return noun.Jam(noun.MakeNoun([]interface{}{id.Ship, id.Life, noun.LittleToBig(ring), 0}))
+}
+
+// Keyfile prints the seed as @uw, as in a .key file or from |moon
+func (id *Identity) Keyfile() string {
+	return noun.BN2uw(id.Seed())
+}
+
+// Pass is the public keys as an urbit pass: 'b', the signing key then
+// the encryption key
+func (id *Identity) Pass() *big.Int {
+	sgn := urcrypt.UrcryptEdPuck(id.AuthKey)
+	cry := urcrypt.UrcryptEdPuck(id.CryptKey)
+	b := append([]byte{'b'}, sgn[:]...)
+	return noun.LittleToBig(append(b, cry[:]...))
+}
+
+// shax is sha256 of an atom's bytes
+func shax(a *big.Int) *big.Int {
+	h := sha256.Sum256(noun.BigToLittle(a))
+	return noun.LittleToBig(h[:])
+}
+
+// shaf is a salted sha256 folded in half
+func shaf(sal, ruz *big.Int) *big.Int {
+	haz := shax(noun.B(0).Xor(sal, shax(ruz)))
+	return noun.B(0).Xor(noun.Cut(0, 128, haz), noun.Cut(128, 128, haz))
+}
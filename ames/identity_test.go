@@ -0,0 +1,124 @@
+package ames
+
+import (
+	"context"
+	mrand "math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stevelacy/go-urbit/noun"
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+func TestKeyfileIdentity(t *testing.T) {
+	auth := [32]byte{1}
+	cry := [32]byte{2}
+	ring := noun.LittleToBig(append(append([]byte{'B'}, auth[:]...), cry[:]...))
+	for _, ship := range []int64{0x10100, 0x7e7100010100} {
+		keyfile := noun.Jam(noun.MakeNoun([]interface{}{ship, 3, ring, 0})).String()
+		id, err := NewKeyfileIdentity(keyfile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id.Ship.Int64() != ship || id.Life != 3 || id.AuthKey != auth || id.CryptKey != cry {
+			t.Errorf("expected %x %v %v %v got %v", ship, 3, auth, cry, id)
+		}
+		_, err = NewMoonIdentity(keyfile)
+		if (err == nil) != (rank(id.Ship) == moonRank) {
+			t.Errorf("expected only a moon seed to parse, %x got %v", ship, err)
+		}
+	}
+}
+
+func TestKeyfileEncodings(t *testing.T) {
+	id, err := GenerateIdentity(noun.B(0x7e7100010100), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := id.Seed()
+	keyfile := id.Keyfile()
+	path := filepath.Join(t.TempDir(), "moon.key")
+	os.WriteFile(path, []byte(keyfile+"\n"), 0600)
+
+	for _, s := range []string{keyfile, noun.BN2ux(seed), seed.String()} {
+		r1, err := NewKeyfileIdentity(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r1.Ship.Cmp(id.Ship) != 0 || r1.Life != id.Life || r1.AuthKey != id.AuthKey || r1.CryptKey != id.CryptKey {
+			t.Errorf("expected %v got %v", id, r1)
+		}
+	}
+	r2, err := LoadKeyfile(path)
+	if err != nil || r2.Seed().Cmp(seed) != 0 {
+		t.Errorf("expected %v got %v %v", id, r2, err)
+	}
+	_, err = ParseKey("0xnope")
+	if err == nil {
+		t.Errorf("expected a bad key to fail")
+	}
+}
+
+func TestMineComet(t *testing.T) {
+	ctx := context.Background()
+	id, err := mineComet(ctx, nil, mrand.New(mrand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rank(id.Ship) != cometRank || id.Life != 1 {
+		t.Errorf("expected a comet got %x", id.Ship)
+	}
+	fig := shaf(noun.StringToCord("bfig").Value, id.Pass())
+	if fig.Cmp(id.Ship) != 0 {
+		t.Errorf("expected %x got %x", fig, id.Ship)
+	}
+
+	// the same keys come out first when they match the star
+	r1, err := mineComet(ctx, sein(id.Ship), mrand.New(mrand.NewSource(1)))
+	if err != nil || r1.Ship.Cmp(id.Ship) != 0 {
+		t.Errorf("expected %x got %v %v", id.Ship, r1, err)
+	}
+
+	_, err = mineComet(ctx, noun.B(0x10100), mrand.New(mrand.NewSource(1)))
+	if err == nil {
+		t.Errorf("expected a planet sponsor to fail")
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = mineComet(cctx, noun.B(0x100), mrand.New(mrand.NewSource(1)))
+	if err != context.Canceled {
+		t.Errorf("expected %v got %v", context.Canceled, err)
+	}
+}
+
+func TestAttestation(t *testing.T) {
+	id, err := mineComet(context.Background(), nil, mrand.New(mrand.NewSource(2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &Ames{Ship: id.Ship, Life: id.Life, PrivateKey: id.CryptKey, authKey: id.AuthKey}
+	peer := &Peer{ship: noun.B(0x100), life: 4}
+
+	sender, receiver, _, _, content, err := DecodePacket(a.attestation(peer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sender.Cmp(id.Ship) != 0 || receiver.Cmp(peer.ship) != 0 {
+		t.Errorf("expected %x %x got %x %x", id.Ship, peer.ship, sender, receiver)
+	}
+	c := noun.Cue(content)
+	sig, _ := noun.AssertAtom(noun.Head(c))
+	signed, _ := noun.AssertAtom(noun.Tail(c))
+	var r1 [64]byte
+	copy(r1[:], noun.BigToLittle(sig.Value))
+	var pub [32]byte
+	copy(pub[:], noun.BigToLittle(noun.Cut(8, 256, id.Pass())))
+	if !urcrypt.UrcryptEdVeri(noun.BigToLittle(signed.Value), r1, pub) {
+		t.Errorf("expected a valid signature")
+	}
+	open := noun.Cue(signed.Value)
+	if noun.MakeNoun(id.Pass()).String() != noun.Head(open).String() {
+		t.Errorf("expected %v got %v", id.Pass(), noun.Head(open))
+	}
+}
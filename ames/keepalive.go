@@ -0,0 +1,132 @@
+package ames
+
+import (
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+// Keepalive configures the probes that keep our sponsor aware of our
+// lane and our NAT mapping open. The zero value probes with the defaults
+type Keepalive struct {
+	Disabled bool
+	// Interval is the time between probes while the sponsor answers,
+	// 25s by default, under the UDP idle timeout of most NATs. When a
+	// probe goes unanswered the lane is taken as lost and the interval
+	// halves down to MinInterval, 5s, then doubles back to Interval
+	// once probes are answered again
+	Interval    time.Duration
+	MinInterval time.Duration
+	// Timeout is how long a probe waits for its answer, 10s by default
+	Timeout time.Duration
+	// Misses is how many probes in a row go unanswered before the
+	// sponsor is reported down, 3 by default
+	Misses int
+	// OnSponsor is called when the sponsor stops responding, and when
+	// it responds again
+	OnSponsor func(sponsor string, up bool)
+}
+
+func (k Keepalive) withDefaults() Keepalive {
+	if k.Interval == 0 {
+		k.Interval = 25 * time.Second
+	}
+	if k.MinInterval == 0 {
+		k.MinInterval = 5 * time.Second
+	}
+	if k.Timeout == 0 {
+		k.Timeout = 10 * time.Second
+	}
+	if k.Misses == 0 {
+		k.Misses = 3
+	}
+	return k
+}
+
+// startKeepalive probes our sponsor until we close
+func (a *Ames) startKeepalive() error {
+	a.mut.Lock()
+	sponsor := a.sponsor
+	a.mut.Unlock()
+	peer, err := a.GetPeer(sponsor)
+	if err != nil {
+		return err
+	}
+	c := a.openConnection(peer)
+	k := a.opts.Keepalive.withDefaults()
+	if !a.spawn(func() { a.keepalive(c, k) }) {
+		return ErrClosed
+	}
+	return nil
+}
+
+// probe sends the sponsor a fragment of message 0 on the flow. Every
+// sink has acked message 0 before it hears anything, so the sponsor
+// answers with a bare ack and delivers nothing. No message num is used
+// and nothing is saved
+func (c *Connection) probe() error {
+	pkts, err := c.encodeMessage(0, noun.MakeNoun(0))
+	if err != nil {
+		return err
+	}
+	return c.write(pkts[0])
+}
+
+// onProbeAck hears the answer to a probe
+func (a *Ames) onProbeAck() {
+	select {
+	case a.probed <- struct{}{}:
+	default:
+	}
+}
+
+func (a *Ames) keepalive(c *Connection, k Keepalive) {
+	interval := k.Interval
+	misses := 0
+	for {
+		// an answer to an earlier probe doesn't count for this one
+		select {
+		case <-a.probed:
+		default:
+		}
+		answered := false
+		err := c.probe()
+		if err != nil {
+			a.fault("keepalive", c.Peer.ship, err)
+		} else {
+			select {
+			case <-a.probed:
+				answered = true
+			case <-time.After(k.Timeout):
+			case <-a.quit:
+				return
+			}
+		}
+
+		wasDown := misses >= k.Misses
+		if answered {
+			misses = 0
+			interval = min(interval*2, k.Interval)
+		} else {
+			misses++
+			interval = max(interval/2, k.MinInterval)
+		}
+		a.probeInterval.Store(int64(interval))
+
+		switch {
+		case answered && wasDown:
+			a.logger().Info("sponsor is responding", "peer", shipName(c.Peer.ship))
+			if k.OnSponsor != nil {
+				k.OnSponsor(shipName(c.Peer.ship), true)
+			}
+		case misses == k.Misses:
+			a.logger().Warn("sponsor stopped responding", "peer", shipName(c.Peer.ship), "misses", misses)
+			if k.OnSponsor != nil {
+				k.OnSponsor(shipName(c.Peer.ship), false)
+			}
+		}
+		if !a.sleep(interval) {
+			return
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package ames
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeepalive(t *testing.T) {
+	status := make(chan bool, 4)
+	k := Keepalive{
+		Interval:    50 * time.Millisecond,
+		MinInterval: 10 * time.Millisecond,
+		Timeout:     50 * time.Millisecond,
+		Misses:      2,
+		OnSponsor: func(sponsor string, up bool) {
+			status <- up
+		},
+	}
+	a, b := testPairOptions(t, Options{Keepalive: k})
+	defer a.Close(context.Background())
+	a.mut.Lock()
+	a.sponsor = b.Ship
+	a.mut.Unlock()
+
+	err := a.startKeepalive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for a.probeInterval.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !a.connected.Load() {
+		t.Errorf("expected to be connected once the sponsor answered")
+	}
+	if r1 := time.Duration(a.probeInterval.Load()); r1 != k.Interval {
+		t.Errorf("expected %v got %v", k.Interval, r1)
+	}
+	// probes use no message num and deliver nothing
+	for _, c := range a.connections() {
+		c.mut.Lock()
+		num, msgs := c.num, len(c.msgs)
+		c.mut.Unlock()
+		if num != 1 || msgs != 0 {
+			t.Errorf("expected no messages sent got %v %v", num, msgs)
+		}
+	}
+	for _, c := range b.connections() {
+		c.mut.Lock()
+		heard := c.sink.lastHeard
+		c.mut.Unlock()
+		if heard != 0 {
+			t.Errorf("expected nothing delivered got %v", heard)
+		}
+	}
+
+	// the sponsor goes away
+	b.Close(context.Background())
+	select {
+	case up := <-status:
+		if up {
+			t.Errorf("expected the sponsor to be reported down")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the sponsor to be reported down")
+	}
+	if r2 := time.Duration(a.probeInterval.Load()); r2 >= k.Interval {
+		t.Errorf("expected the interval to shrink below %v got %v", k.Interval, r2)
+	}
+}
+
+func TestKeepaliveDefaults(t *testing.T) {
+	k := Keepalive{Interval: time.Minute}.withDefaults()
+	if k.Interval != time.Minute || k.MinInterval != 5*time.Second || k.Timeout != 10*time.Second || k.Misses != 3 {
+		t.Errorf("expected defaults around the interval got %v", k)
+	}
+}
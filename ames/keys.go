@@ -0,0 +1,250 @@
+package ames
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+// resolveInterval limits how often a peer is looked up again, so a
+// burst of packets we can't decrypt isn't a burst of lookups
+var resolveInterval = 10 * time.Second
+
+// recheckInterval limits the lookups made on a hint the peer may have
+// breached, a lossy link gives plenty of those
+var recheckInterval = time.Minute
+
+// lookupTimeout bounds a single lookup
+var lookupTimeout = 10 * time.Second
+
+// ErrLookingUp drops a packet from a ship whose keys are being looked up
+var ErrLookingUp = errors.New("ames: looking up peer")
+
+// ErrBreached ends pokes and subscriptions with a peer that breached
+var ErrBreached = errors.New("ames: peer breached")
+
+// Life is the peer's key revision as we last looked it up
+func (p *Peer) Life() int64 {
+	p.keyMut.RLock()
+	defer p.keyMut.RUnlock()
+	return p.life
+}
+
+// Rift is the number of times the peer has breached
+func (p *Peer) Rift() int64 {
+	p.keyMut.RLock()
+	defer p.keyMut.RUnlock()
+	return p.rift
+}
+
+// keys returns the symmetric key and life to encrypt with
+func (p *Peer) keys() ([]byte, int64) {
+	p.keyMut.RLock()
+	defer p.keyMut.RUnlock()
+	return p.symKey, p.life
+}
+
+func (p *Peer) signingKey() [32]byte {
+	p.keyMut.RLock()
+	defer p.keyMut.RUnlock()
+	return p.authKey
+}
+
+type lookupResult struct {
+	res LookupResponse
+	err error
+}
+
+// lookup resolves a ship's keys with Options.Lookup or azimuth, giving
+// up after lookupTimeout or once we close
+func (a *Ames) lookup(name string) (LookupResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+	done := make(chan lookupResult, 1)
+	go func() {
+		var r lookupResult
+		if a.opts.Lookup != nil {
+			r.res, r.err = a.opts.Lookup(name)
+		} else {
+			r.res, r.err = LookupContext(ctx, name)
+		}
+		done <- r
+	}()
+	select {
+	case r := <-done:
+		return r.res, r.err
+	case <-ctx.Done():
+		return LookupResponse{}, ctx.Err()
+	case <-a.quit:
+		return LookupResponse{}, ErrClosed
+	}
+}
+
+// setKeys stores the result of a lookup, reporting whether the peer has
+// new keys or has breached since the last one
+func (a *Ames) setKeys(p *Peer, res LookupResponse) (rekeyed, breached bool) {
+	// held throughout so a Rekey can't slip in between
+	a.keyMut.RLock()
+	defer a.keyMut.RUnlock()
+	pubKey := keyFromHex(res.EncryptionKey)
+	symKey := urcrypt.UrcryptEdShar(pubKey, a.PrivateKey)
+	p.keyMut.Lock()
+	defer p.keyMut.Unlock()
+	known := p.symKey != nil
+	rekeyed = known && res.Life != p.life
+	breached = known && res.Rift > p.rift
+	p.pubKey = pubKey
+	p.symKey = symKey
+	p.authKey = keyFromHex(res.AuthenticationKey)
+	p.life = res.Life
+	p.rift = res.Rift
+	p.resolved = a.clock.Now()
+	return rekeyed, breached
+}
+
+// resolve looks the peer up again, at most once per resolveInterval. It
+// returns true if the keys changed
+func (a *Ames) resolve(p *Peer) (bool, error) {
+	if rank(p.ship) == cometRank {
+		return false, nil
+	}
+	now := a.clock.Now()
+	p.keyMut.Lock()
+	if now.Sub(p.resolved) < resolveInterval {
+		p.keyMut.Unlock()
+		return false, nil
+	}
+	p.resolved = now
+	p.keyMut.Unlock()
+
+	res, err := a.lookup(shipName(p.ship))
+	if err != nil {
+		return false, err
+	}
+	rekeyed, breached := a.setKeys(p, res)
+	switch {
+	case breached:
+		a.onBreach(p, res.Rift)
+	case rekeyed:
+		a.logger().Info("peer changed keys", "peer", shipName(p.ship), "life", res.Life)
+		a.reencode(p)
+	}
+	return rekeyed || breached, nil
+}
+
+// resolveAsync runs resolve in the background, one at a time per peer,
+// and reports whether it started one. The read loop calls it and drops
+// the packet that prompted it, the sender retries once we have the keys
+func (a *Ames) resolveAsync(p *Peer) bool {
+	if !p.resolving.CompareAndSwap(false, true) {
+		return false
+	}
+	started := a.spawn(func() {
+		defer p.resolving.Store(false)
+		_, err := a.resolve(p)
+		if err != nil {
+			a.fault("lookup", p.ship, err)
+		}
+	})
+	if !started {
+		p.resolving.Store(false)
+	}
+	return started
+}
+
+// recheck looks the peer up again on a hint that it breached without
+// changing keys: a flow that stopped acking us, or one that repeats
+// messages we already acked. Only the rift tells such a breach apart
+func (a *Ames) recheck(p *Peer) bool {
+	p.keyMut.RLock()
+	resolved := p.resolved
+	p.keyMut.RUnlock()
+	if a.clock.Now().Sub(resolved) < recheckInterval {
+		return false
+	}
+	return a.resolveAsync(p)
+}
+
+// sender returns the peer a packet is from. A ship we don't know yet
+// is looked up in the background, and its packet dropped meanwhile
+func (a *Ames) sender(ship *big.Int) (*Peer, error) {
+	if rank(ship) == cometRank || a.knowsPeer(ship) {
+		return a.GetPeer(ship)
+	}
+	a.learn(ship)
+	return nil, ErrLookingUp
+}
+
+// learn looks up a ship we don't know in the background, one lookup
+// at a time per ship
+func (a *Ames) learn(ship *big.Int) {
+	name := shipName(ship)
+	a.peerMut.Lock()
+	if a.learning == nil {
+		a.learning = make(map[string]bool)
+	}
+	busy := a.learning[name]
+	a.learning[name] = true
+	a.peerMut.Unlock()
+	if busy {
+		return
+	}
+	started := a.spawn(func() {
+		_, err := a.GetPeer(ship)
+		if err != nil {
+			a.fault("lookup", ship, err)
+		}
+		a.peerMut.Lock()
+		delete(a.learning, name)
+		a.peerMut.Unlock()
+	})
+	if !started {
+		a.peerMut.Lock()
+		delete(a.learning, name)
+		a.peerMut.Unlock()
+	}
+}
+
+// reencode encrypts every unacked message again with the peer's new
+// keys, the peer can't read what is already in flight
+func (a *Ames) reencode(p *Peer) {
+	p.mut.Lock()
+	conns := []*Connection{}
+	for _, c := range p.Connections {
+		conns = append(conns, c)
+	}
+	p.mut.Unlock()
+
+	for _, c := range conns {
+		c.mut.Lock()
+		for num, msg := range c.msgs {
+			pkts, err := c.encodeMessage(num, msg)
+			if err == nil {
+				c.pump.Replace(num, pkts)
+			}
+		}
+		c.mut.Unlock()
+	}
+}
+
+// onBreach forgets every flow with a peer that breached. It has
+// forgotten them too, so new flows start again from bone 1
+func (a *Ames) onBreach(p *Peer, rift int64) {
+	p.mut.Lock()
+	conns := p.Connections
+	p.Connections = make(map[int]*Connection)
+	p.nextBone = 1
+	p.mut.Unlock()
+
+	for _, c := range conns {
+		c.abandon(ErrBreached)
+	}
+	a.dirty.Store(true)
+	a.logger().Info("peer breached", "peer", shipName(p.ship), "rift", rift)
+	if a.opts.OnBreach != nil {
+		a.opts.OnBreach(shipName(p.ship), rift)
+	}
+}
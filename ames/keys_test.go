@@ -0,0 +1,199 @@
+package ames
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+func TestParsePoint(t *testing.T) {
+	word := func(s string) string {
+		return padLeft(s, 64, "0")
+	}
+	words := []string{pEKey, pAKey, word("1"), word("1"), word("0"), word("100"), word("0"), word("1"), word("12"), word("3")}
+	res, err := parsePoint("0x" + strings.Join(words, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.EncryptionKey != pEKey || !res.HasSponsor || res.Life != 18 || res.Rift != 3 {
+		t.Errorf("expected %v %v %v %v got %v", pEKey, true, 18, 3, res)
+	}
+	_, err = parsePoint("0x" + pEKey)
+	if err == nil {
+		t.Errorf("expected a short response to fail")
+	}
+	_, err = parsePoint("0x" + strings.Repeat("0", 64*10))
+	if err != ErrNotFound {
+		t.Errorf("expected %v got %v", ErrNotFound, err)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	clock := newFakeClock()
+	point := LookupResponse{EncryptionKey: pEKey, AuthenticationKey: pAKey, Life: 1}
+	lookups := 0
+	breaches := []int64{}
+	a := &Ames{
+		Ship:  noun.B(0x10100),
+		Peers: make(map[string]*Peer),
+		clock: clock,
+		opts: Options{
+			Lookup: func(name string) (LookupResponse, error) {
+				lookups++
+				return point, nil
+			},
+			OnBreach: func(ship string, rift int64) {
+				breaches = append(breaches, rift)
+			},
+		},
+	}
+	peer, err := a.GetPeer(noun.B(0x10200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := a.openConnection(peer)
+	f := pokeFuture(c, 1)
+
+	// nothing changed, and a second try is too soon to look up again
+	clock.Advance(resolveInterval)
+	changed, err := a.resolve(peer)
+	if changed || err != nil {
+		t.Errorf("expected no change got %v %v", changed, err)
+	}
+	a.resolve(peer)
+	if lookups != 2 {
+		t.Errorf("expected %v got %v", 2, lookups)
+	}
+
+	// new keys keep the flows
+	point.Life = 2
+	clock.Advance(resolveInterval)
+	changed, _ = a.resolve(peer)
+	if !changed || peer.Life() != 2 || len(peer.Connections) != 1 {
+		t.Errorf("expected life 2 with one flow got %v %v %v", changed, peer.Life(), len(peer.Connections))
+	}
+
+	// a breach resets them
+	point.Rift = 1
+	clock.Advance(resolveInterval)
+	changed, _ = a.resolve(peer)
+	if !changed || peer.Rift() != 1 || len(peer.Connections) != 0 || peer.nextBone != 1 {
+		t.Errorf("expected rift 1 with no flows got %v %v %v", changed, peer.Rift(), len(peer.Connections))
+	}
+	if f.Wait(context.Background()) != ErrBreached {
+		t.Errorf("expected %v got %v", ErrBreached, f.Err())
+	}
+	if len(breaches) != 1 || breaches[0] != 1 {
+		t.Errorf("expected %v got %v", []int64{1}, breaches)
+	}
+}
+
+// TestResolveAsync checks lookups don't hold up the read loop, and that
+// a breach that kept the same keys is found by its rift
+func TestResolveAsync(t *testing.T) {
+	defer func(r, c time.Duration) { resolveInterval, recheckInterval = r, c }(resolveInterval, recheckInterval)
+	resolveInterval, recheckInterval = 0, 0
+	point := LookupResponse{EncryptionKey: pEKey, AuthenticationKey: pAKey, Life: 1}
+	var mut sync.Mutex
+	release := make(chan struct{})
+	breached := make(chan int64, 1)
+	got := make(chan Plea, 8)
+	a, b := recordedPair(t, Options{
+		Handler: HandlerFunc(func(c *Connection, p Plea) error {
+			got <- p
+			return nil
+		}),
+		Lookup: func(name string) (LookupResponse, error) {
+			<-release
+			mut.Lock()
+			defer mut.Unlock()
+			return point, nil
+		},
+		OnBreach: func(ship string, rift int64) {
+			breached <- rift
+		},
+	})
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+	// b shares the key a derives from the lookup, and a has yet to
+	// look b up
+	peer, _ := b.GetPeer(a.Ship)
+	peer.keyMut.Lock()
+	peer.symKey = urcrypt.UrcryptEdShar(keyFromHex(pEKey), a.PrivateKey)
+	peer.keyMut.Unlock()
+	a.peerMut.Lock()
+	delete(a.Peers, shipName(b.Ship))
+	a.peerMut.Unlock()
+
+	c, err := b.Connect(shipName(a.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	f, err := c.PokeAsync([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("one"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the packet is dropped while the lookup runs, a is still reading
+	time.Sleep(100 * time.Millisecond)
+	if f.Err() != nil || a.knowsPeer(b.Ship) {
+		t.Errorf("expected the poke to wait on the lookup got %v", f.Err())
+	}
+	close(release)
+	if err = f.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	err = c.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("two"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b breaches with the same keys and starts its flows over
+	mut.Lock()
+	point.Rift = 1
+	mut.Unlock()
+	b.onBreach(peer, 0)
+	c, err = b.Connect(shipName(a.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("three"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case rift := <-breached:
+		if rift != 1 {
+			t.Errorf("expected %v got %v", 1, rift)
+		}
+	case <-ctx.Done():
+		t.Fatal("expected a breach")
+	}
+	for _, want := range []string{"one", "two", "three"} {
+		p := (<-got).(Poke)
+		if p.Data.String() != noun.MakeNoun(want).String() {
+			t.Errorf("expected %v got %v", want, p.Data)
+		}
+	}
+}
+
+func TestResolveTimeout(t *testing.T) {
+	defer func(d time.Duration) { lookupTimeout = d }(lookupTimeout)
+	lookupTimeout = 10 * time.Millisecond
+	block := make(chan struct{})
+	defer close(block)
+	a := &Ames{opts: Options{Lookup: func(name string) (LookupResponse, error) {
+		<-block
+		return LookupResponse{}, nil
+	}}}
+	_, err := a.lookup("~zod")
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v got %v", context.DeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,131 @@
+package ames
+
+import (
+	"errors"
+	"math/big"
+	"net"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+// laneTimeout is how long a direct lane is trusted without hearing
+// from the peer on it
+var laneTimeout = 30 * time.Second
+
+// lane is where we last heard a peer from. A lane learned from a relay's
+// origin is not direct until the peer reaches us on it
+type lane struct {
+	addr      *net.UDPAddr
+	direct    bool
+	lastHeard time.Time
+}
+
+// EncodeLane packs an IPv4 address and port into a 48-bit origin,
+// the address in the low 32 bits and the port above it
+func EncodeLane(addr *net.UDPAddr) (*big.Int, error) {
+	ip := addr.IP.To4()
+	if ip == nil {
+		return noun.B(0), errors.New("lane: not an ipv4 address")
+	}
+	ipv := uint64(ip[0])<<24 | uint64(ip[1])<<16 | uint64(ip[2])<<8 | uint64(ip[3])
+	return noun.B(0).SetUint64(ipv | uint64(addr.Port)<<32), nil
+}
+
+// DecodeLane is the reverse of EncodeLane
+func DecodeLane(origin *big.Int) *net.UDPAddr {
+	v := noun.Cut(0, 48, origin).Uint64()
+	return &net.UDPAddr{
+		IP:   net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v)),
+		Port: int(v >> 32 & 0xffff),
+	}
+}
+
+// RelayPacket adds the origin lane to a packet being forwarded, as a
+// galaxy does. Packets that already carry an origin are unchanged
+func RelayPacket(pkt []byte, origin *net.UDPAddr) ([]byte, error) {
+	if len(pkt) < 4 {
+		return nil, errors.New("error: packet too short")
+	}
+	if pkt[3]>>7&0b1 == 0 {
+		return pkt, nil
+	}
+	o, err := EncodeLane(origin)
+	if err != nil {
+		return nil, err
+	}
+	ob := make([]byte, 6)
+	copy(ob, noun.BigToLittle(o))
+
+	out := make([]byte, 0, len(pkt)+6)
+	out = append(out, pkt[:4]...)
+	out[3] &^= 1 << 7
+	out = append(out, ob...)
+	return append(out, pkt[4:]...), nil
+}
+
+// PacketOrigin returns the origin lane of a relayed packet
+func PacketOrigin(pkt []byte) (*net.UDPAddr, bool) {
+	header, _, err := decodeHeader(pkt)
+	if err != nil || !header.relayed {
+		return nil, false
+	}
+	return DecodeLane(header.origin), true
+}
+
+// learnLane records where a packet from the peer came from. A packet
+// sent to us directly confirms the source address, a relayed one only
+// suggests the origin
+func (p *Peer) learnLane(src *net.UDPAddr, origin *net.UDPAddr, now time.Time) {
+	p.laneMut.Lock()
+	defer p.laneMut.Unlock()
+	if origin == nil {
+		if src == nil {
+			return
+		}
+		p.lane = lane{addr: src, direct: true, lastHeard: now}
+		return
+	}
+	// keep a working direct lane over a relay's view of the peer
+	if p.lane.direct && now.Sub(p.lane.lastHeard) < laneTimeout {
+		return
+	}
+	p.lane = lane{addr: origin, lastHeard: now}
+}
+
+// hasDirectLane reports whether the peer has been heard from directly
+// lately, so packets go to it alone
+func (p *Peer) hasDirectLane(now time.Time) bool {
+	p.laneMut.Lock()
+	defer p.laneMut.Unlock()
+	return p.lane.addr != nil && p.lane.direct && now.Sub(p.lane.lastHeard) < laneTimeout
+}
+
+// routes returns where to send a packet for the peer. A fresh direct
+// lane is used alone, an unconfirmed or quiet one is tried alongside
+// the relay. relay is nil when the peer's galaxy can't be resolved
+func (p *Peer) routes(relay *net.UDPAddr, now time.Time) []*net.UDPAddr {
+	p.laneMut.Lock()
+	defer p.laneMut.Unlock()
+	l := p.lane
+	if l.addr == nil {
+		if relay == nil {
+			return nil
+		}
+		return []*net.UDPAddr{relay}
+	}
+	if relay == nil || l.direct && now.Sub(l.lastHeard) < laneTimeout {
+		return []*net.UDPAddr{l.addr}
+	}
+	if l.addr.String() == relay.String() {
+		return []*net.UDPAddr{relay}
+	}
+	return []*net.UDPAddr{l.addr, relay}
+}
+
+// Lane returns the peer's last known lane and whether it is direct
+func (p *Peer) Lane() (*net.UDPAddr, bool) {
+	p.laneMut.Lock()
+	defer p.laneMut.Unlock()
+	return p.lane.addr, p.lane.direct
+}
@@ -0,0 +1,108 @@
+package ames
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+func TestEncodeLane(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 13337}
+	o, err := EncodeLane(addr)
+	if err != nil {
+		t.Error(err)
+	}
+	// port above the address
+	if o.Uint64() != 0x341901020304 {
+		t.Errorf("expected %x got %x", 0x341901020304, o)
+	}
+	r1 := DecodeLane(o)
+	if r1.String() != addr.String() {
+		t.Errorf("expected %v got %v", addr, r1)
+	}
+
+	_, err = EncodeLane(&net.UDPAddr{IP: net.ParseIP("::1"), Port: 1})
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestRelayPacket(t *testing.T) {
+	from := noun.B(0x10100)
+	to := noun.B(0)
+	pkt := encodeFinePacket(from, to, 1, 1, true, []byte("hello"))
+
+	if _, ok := PacketOrigin(pkt); ok {
+		t.Errorf("expected no origin")
+	}
+
+	origin := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 31337}
+	relayed, err := RelayPacket(pkt, origin)
+	if err != nil {
+		t.Error(err)
+	}
+	r1, ok := PacketOrigin(relayed)
+	if !ok || r1.String() != origin.String() {
+		t.Errorf("expected %v got %v", origin, r1)
+	}
+
+	// the checksum excludes the origin so the packet still decodes
+	f1, t1, _, c1, err := DecodeFinePacket(relayed)
+	if err != nil {
+		t.Error(err)
+	}
+	if f1.Cmp(from) != 0 || t1.Cmp(to) != 0 {
+		t.Errorf("expected %v %v got %v %v", from, to, f1, t1)
+	}
+	if string(noun.BigToLittle(c1)) != "hello" {
+		t.Errorf("expected %v got %v", "hello", string(noun.BigToLittle(c1)))
+	}
+
+	// a second relay keeps the first origin
+	again, _ := RelayPacket(relayed, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1})
+	r2, _ := PacketOrigin(again)
+	if r2.String() != origin.String() {
+		t.Errorf("expected %v got %v", origin, r2)
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	clk := newFakeClock()
+	relay := &net.UDPAddr{IP: net.IPv4(1, 1, 1, 1), Port: 13337}
+	origin := &net.UDPAddr{IP: net.IPv4(2, 2, 2, 2), Port: 1000}
+	direct := &net.UDPAddr{IP: net.IPv4(3, 3, 3, 3), Port: 2000}
+	p := &Peer{}
+
+	r1 := p.routes(relay, clk.Now())
+	if len(r1) != 1 || r1[0] != relay {
+		t.Errorf("expected %v got %v", []*net.UDPAddr{relay}, r1)
+	}
+
+	// an origin is tried alongside the relay until confirmed
+	p.learnLane(relay, origin, clk.Now())
+	r2 := p.routes(relay, clk.Now())
+	if len(r2) != 2 || r2[0] != origin || r2[1] != relay {
+		t.Errorf("expected %v got %v", []*net.UDPAddr{origin, relay}, r2)
+	}
+
+	p.learnLane(direct, nil, clk.Now())
+	r3 := p.routes(relay, clk.Now())
+	if len(r3) != 1 || r3[0] != direct {
+		t.Errorf("expected %v got %v", []*net.UDPAddr{direct}, r3)
+	}
+
+	// a relayed packet doesn't replace a working direct lane
+	p.learnLane(relay, origin, clk.Now())
+	if addr, ok := p.Lane(); addr != direct || !ok {
+		t.Errorf("expected %v got %v", direct, addr)
+	}
+
+	// once quiet the relay is used as well
+	clk.Advance(laneTimeout + time.Second)
+	r4 := p.routes(relay, clk.Now())
+	if len(r4) != 2 || r4[0] != direct || r4[1] != relay {
+		t.Errorf("expected %v got %v", []*net.UDPAddr{direct, relay}, r4)
+	}
+}
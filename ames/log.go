@@ -0,0 +1,77 @@
+package ames
+
+import (
+	"context"
+	"log/slog"
+	"math/big"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+// Fault is a non-fatal error met while handling the network. Faults
+// are logged and passed to Options.OnError
+type Fault struct {
+	Op   string // read, decode, open, lookup, decrypt, deliver, send, save, keepalive or scry
+	Ship string // the peer, when known
+	Err  error
+}
+
+func (f *Fault) Error() string {
+	if f.Ship == "" {
+		return f.Op + ": " + f.Err.Error()
+	}
+	return f.Op + " " + f.Ship + ": " + f.Err.Error()
+}
+
+func (f *Fault) Unwrap() error {
+	return f.Err
+}
+
+// fault reports a non-fatal error
+func (a *Ames) fault(op string, ship *big.Int, err error) {
+	f, ok := err.(*Fault)
+	if !ok {
+		f = &Fault{Op: op, Err: err}
+		if ship != nil {
+			f.Ship = shipName(ship)
+		}
+	}
+	a.logger().Warn("fault", "op", f.Op, "peer", f.Ship, "err", f.Err)
+	if a.opts.OnError != nil {
+		a.opts.OnError(f)
+	}
+}
+
+func (a *Ames) logger() *slog.Logger {
+	if a.opts.Logger == nil {
+		return discardLogger
+	}
+	return a.opts.Logger
+}
+
+// debug logs at debug level with the flow's peer and bone. Attributes
+// are only built when debug is enabled as this runs for every packet
+func (c *Connection) debug(msg string, args ...any) {
+	l := c.ames.logger()
+	if !l.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	l.Debug(msg, append([]any{"peer", shipName(c.Peer.ship), "bone", c.bone}, args...)...)
+}
+
+func shipName(ship *big.Int) string {
+	name, err := noun.BN2patp(ship)
+	if err != nil {
+		return ship.Text(16)
+	}
+	return name
+}
+
+var discardLogger = slog.New(discardHandler{})
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -0,0 +1,58 @@
+package ames
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+func TestFault(t *testing.T) {
+	var faults []error
+	var buf bytes.Buffer
+	a := &Ames{opts: Options{
+		Logger:  slog.New(slog.NewTextHandler(&buf, nil)),
+		OnError: func(err error) { faults = append(faults, err) },
+	}}
+
+	// flip a bit of the body so the checksum fails
+	pkt := encodeFinePacket(noun.B(0x100), noun.B(0), 1, 1, true, []byte("hello"))
+	pkt[len(pkt)-1] ^= 1
+	_, _, err := a.ParsePacket(pkt)
+	if !errors.Is(err, ErrChecksum) {
+		t.Errorf("expected %v got %v", ErrChecksum, err)
+	}
+	a.fault("decode", nil, err)
+
+	if len(faults) != 1 {
+		t.Fatalf("expected %v got %v", 1, len(faults))
+	}
+	var f *Fault
+	if !errors.As(faults[0], &f) || f.Op != "decode" {
+		t.Errorf("expected a decode fault got %v", faults[0])
+	}
+	if !strings.Contains(buf.String(), "op=decode") {
+		t.Errorf("expected the fault to be logged got %s", buf.String())
+	}
+}
+
+func TestDebugAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	a := &Ames{opts: Options{
+		Logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}}
+	c := &Connection{ames: a, bone: 1, Peer: &Peer{ship: noun.B(0)}}
+	c.debug("heard fragment", "num", 2, "fragment", 3)
+
+	r1 := buf.String()
+	if !strings.Contains(r1, "peer=~zod bone=1 num=2 fragment=3") {
+		t.Errorf("expected peer, bone, num and fragment got %s", r1)
+	}
+
+	// no logger logs nothing
+	c.ames = &Ames{}
+	c.debug("heard fragment")
+}
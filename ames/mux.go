@@ -0,0 +1,108 @@
+package ames
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Handler serves pleas sent to us. Returning nil acks the plea, an
+// error nacks it and is sent back as the tang. Pleas on one flow are
+// served one at a time in order, off the read loop, so a handler may
+// wait on the peer that sent it
+type Handler interface {
+	ServePlea(c *Connection, p Plea) error
+}
+
+// HandlerFunc lets a function be used as a Handler
+type HandlerFunc func(c *Connection, p Plea) error
+
+func (f HandlerFunc) ServePlea(c *Connection, p Plea) error {
+	return f(c, p)
+}
+
+// PokeHandler serves a poke of one app and mark
+type PokeHandler func(c *Connection, p Poke) error
+
+type pokeRoute struct {
+	app, mark string
+}
+
+// ServeMux routes pokes to handlers by app and mark, like
+// net/http.ServeMux. Pokes without a handler and watches are nacked,
+// leaves and corks are acked
+type ServeMux struct {
+	mut   sync.RWMutex
+	pokes map[pokeRoute]PokeHandler
+}
+
+func NewServeMux() *ServeMux {
+	return &ServeMux{pokes: make(map[pokeRoute]PokeHandler)}
+}
+
+// HandlePoke registers fn for pokes to app with mark
+func (m *ServeMux) HandlePoke(app, mark string, fn PokeHandler) {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+	if fn == nil {
+		panic("ames: nil poke handler")
+	}
+	r := pokeRoute{app, mark}
+	if _, ok := m.pokes[r]; ok {
+		panic("ames: multiple registrations for " + app + " " + mark)
+	}
+	m.pokes[r] = fn
+}
+
+func (m *ServeMux) ServePlea(c *Connection, p Plea) error {
+	switch t := p.(type) {
+	case Poke:
+		m.mut.RLock()
+		fn, ok := m.pokes[pokeRoute{t.App, t.Mark}]
+		m.mut.RUnlock()
+		if !ok {
+			return &NackError{Tag: "poke-fail", Tang: []string{fmt.Sprintf("no handler for %s %s", t.App, t.Mark)}}
+		}
+		return fn(c, t)
+	case Watch:
+		return &NackError{Tag: "watch-fail", Tang: []string{"subscriptions are not served by " + t.App}}
+	default:
+		return nil
+	}
+}
+
+// servePlea hands a plea to the configured Handler, or OnPacket which
+// acks everything. A panicking handler nacks the plea
+func (a *Ames) servePlea(c *Connection, p Plea) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panic: %v", r)
+		}
+	}()
+	if a.opts.Handler != nil {
+		return a.opts.Handler.ServePlea(c, p)
+	}
+	if a.OnPacket != nil {
+		a.OnPacket(c, p)
+	}
+	return nil
+}
+
+// nackError tags a plain error by the kind of plea it failed
+func nackError(p Plea, err error) *NackError {
+	if ne, ok := err.(*NackError); ok {
+		return ne
+	}
+	tag := "poke-fail"
+	switch p.(type) {
+	case Watch:
+		tag = "watch-fail"
+	case Leave:
+		tag = "leave-fail"
+	case Cork:
+		tag = "cork-fail"
+	case nil:
+		tag = "plea-fail"
+	}
+	return &NackError{Tag: tag, Tang: strings.Split(err.Error(), "\n")}
+}
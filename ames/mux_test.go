@@ -0,0 +1,113 @@
+package ames
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+func TestServeMux(t *testing.T) {
+	mux := NewServeMux()
+	var got Poke
+	mux.HandlePoke("my-app", "my-action", func(c *Connection, p Poke) error {
+		got = p
+		return nil
+	})
+	mux.HandlePoke("my-app", "fail", func(c *Connection, p Poke) error {
+		return errors.New("no thanks")
+	})
+
+	poke := Poke{App: "my-app", Mark: "my-action", Data: noun.MakeNoun("hi")}
+	err := mux.ServePlea(nil, poke)
+	if err != nil {
+		t.Error(err)
+	}
+	if got.Data.String() != poke.Data.String() {
+		t.Errorf("expected %v got %v", poke, got)
+	}
+
+	err = mux.ServePlea(nil, Poke{App: "my-app", Mark: "fail"})
+	if err == nil || err.Error() != "no thanks" {
+		t.Errorf("expected %v got %v", "no thanks", err)
+	}
+
+	// unknown routes are nacked
+	err = mux.ServePlea(nil, Poke{App: "other-app", Mark: "my-action"})
+	nerr, ok := err.(*NackError)
+	if !ok || nerr.Tag != "poke-fail" {
+		t.Errorf("expected a poke-fail nack got %v", err)
+	}
+
+	err = mux.ServePlea(nil, Cork{})
+	if err != nil {
+		t.Errorf("expected %v got %v", nil, err)
+	}
+}
+
+func TestServePleaPanic(t *testing.T) {
+	a := &Ames{opts: Options{Handler: HandlerFunc(func(c *Connection, p Plea) error {
+		panic("boom")
+	})}}
+	err := a.servePlea(nil, Poke{App: "my-app"})
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+
+	nerr := nackError(Watch{}, errors.New("one\ntwo"))
+	if nerr.Tag != "watch-fail" || len(nerr.Tang) != 2 {
+		t.Errorf("expected %v got %v", "watch-fail", nerr)
+	}
+}
+
+// TestServePleaAsync checks handlers run off the read loop: one can poke
+// the peer that poked it, and a poke is only acked once its handler is
+// done
+func TestServePleaAsync(t *testing.T) {
+	release := make(chan struct{})
+	mux := NewServeMux()
+	mux.HandlePoke("my-app", "call-back", func(c *Connection, p Poke) error {
+		back, err := c.ames.Connect(shipName(c.Peer.ship))
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return back.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("back"))
+	})
+	mux.HandlePoke("my-app", "wait", func(c *Connection, p Poke) error {
+		<-release
+		return nil
+	})
+	a, b := recordedPair(t, Options{Handler: mux})
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+
+	c, err := b.Connect(shipName(a.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = c.Poke(ctx, []string{"ge", "my-app"}, "call-back", noun.MakeNoun(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := c.PokeAsync([]string{"ge", "my-app"}, "wait", noun.MakeNoun(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-f.Done():
+		t.Fatal("expected the ack to wait for the handler")
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(release)
+	err = f.Wait(ctx)
+	if err != nil {
+		t.Errorf("expected %v got %v", nil, err)
+	}
+}
@@ -0,0 +1,148 @@
+package ames
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/stevelacy/go-urbit/noun"
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+// OpenPacket is signed with the sender's auth key rather than encrypted.
+// Comets send one ahead of their messages, peers have no other way to
+// learn a comet's keys
+type OpenPacket struct {
+	PublicKey    *big.Int // the sender's pass
+	Sender       *big.Int
+	SenderLife   int64
+	Receiver     *big.Int
+	ReceiverLife int64
+}
+
+// EncodeOpenPacket signs the packet with the sender's auth seed and
+// returns it ready for the wire
+func EncodeOpenPacket(p OpenPacket, authKey [32]byte) []byte {
+	signed := noun.Jam(noun.MakeNoun([]interface{}{p.PublicKey, p.Sender, p.SenderLife, p.Receiver, p.ReceiverLife}))
+	sig := urcrypt.UrcryptEdSign(noun.BigToLittle(signed), authKey)
+	content := noun.Jam(noun.MakeNoun([]interface{}{noun.LittleToBig(sig[:]), signed}))
+	pkt := noun.MakeNoun([]interface{}{[]interface{}{p.Sender, p.Receiver}, p.SenderLife % 16, p.ReceiverLife % 16, 0, content})
+	return EncodePacket(pkt)
+}
+
+// DecodeOpenPacket reads the content of an open packet and checks it is
+// signed by the key it carries
+func DecodeOpenPacket(content *big.Int) (OpenPacket, error) {
+	outer, err := noun.SafeCue(content)
+	if err != nil {
+		return OpenPacket{}, err
+	}
+	sig, err1 := noun.AssertAtom(noun.Head(outer))
+	signed, err2 := noun.AssertAtom(noun.Tail(outer))
+	if err1 != nil || err2 != nil || sig.Value.BitLen() > 512 {
+		return OpenPacket{}, errors.New("open packet: not a signature and message")
+	}
+	n, err := noun.SafeCue(signed.Value)
+	if err != nil {
+		return OpenPacket{}, err
+	}
+	fields := []*big.Int{}
+	for i := 0; i < 5; i++ {
+		var a noun.Atom
+		if i < 4 {
+			a, err = noun.AssertAtom(noun.Head(n))
+			n = noun.Tail(n)
+		} else {
+			a, err = noun.AssertAtom(n)
+		}
+		if err != nil {
+			return OpenPacket{}, errors.New("open packet: malformed")
+		}
+		fields = append(fields, a.Value)
+	}
+	p := OpenPacket{
+		PublicKey:    fields[0],
+		Sender:       fields[1],
+		SenderLife:   fields[2].Int64(),
+		Receiver:     fields[3],
+		ReceiverLife: fields[4].Int64(),
+	}
+
+	sgn, _, err := passKeys(p.PublicKey)
+	if err != nil {
+		return OpenPacket{}, err
+	}
+	var sig64 [64]byte
+	copy(sig64[:], noun.BigToLittle(sig.Value))
+	if !urcrypt.UrcryptEdVeri(noun.BigToLittle(signed.Value), sig64, sgn) {
+		return OpenPacket{}, errors.New("open packet: invalid signature")
+	}
+	return p, nil
+}
+
+// passKeys splits a pass into its signing and encryption keys
+func passKeys(pass *big.Int) ([32]byte, [32]byte, error) {
+	var sgn, cry [32]byte
+	if noun.Cut(0, 8, pass).Int64() != 'b' || pass.BitLen() > 8+512 {
+		return sgn, cry, errors.New("open packet: invalid pass")
+	}
+	copy(sgn[:], noun.BigToLittle(noun.Cut(8, 256, pass)))
+	copy(cry[:], noun.BigToLittle(noun.Cut(264, 256, pass)))
+	return sgn, cry, nil
+}
+
+// attestation is the open packet we send ahead of our messages when we
+// are a comet
+func (a *Ames) attestation(peer *Peer) []byte {
+	id := &Identity{Ship: a.Ship}
+	id.CryptKey, id.AuthKey, id.Life = a.ourKeys()
+	return EncodeOpenPacket(OpenPacket{
+		PublicKey:    id.Pass(),
+		Sender:       a.Ship,
+		SenderLife:   id.Life,
+		Receiver:     peer.ship,
+		ReceiverLife: peer.Life(),
+	}, id.AuthKey)
+}
+
+// knowsPeer is true once we have keys for ship
+func (a *Ames) knowsPeer(ship *big.Int) bool {
+	name, err := noun.BN2patp(ship)
+	if err != nil {
+		return false
+	}
+	a.peerMut.RLock()
+	defer a.peerMut.RUnlock()
+	_, ok := a.Peers[name]
+	return ok
+}
+
+// hearOpen takes a comet's attestation and adds it as a peer. The
+// comet's name must be the fingerprint of the keys it attests
+func (a *Ames) hearOpen(from, to, content *big.Int) (*Peer, error) {
+	p, err := DecodeOpenPacket(content)
+	if err != nil {
+		return nil, err
+	}
+	if p.Sender.Cmp(from) != 0 || p.Receiver.Cmp(to) != 0 || to.Cmp(a.Ship) != 0 {
+		return nil, errors.New("open packet: wrong sender or receiver")
+	}
+	if rank(from) != cometRank || shaf(noun.StringToCord("bfig").Value, p.PublicKey).Cmp(from) != 0 {
+		return nil, errors.New("open packet: comet name doesn't match its keys")
+	}
+	sgn, cry, _ := passKeys(p.PublicKey)
+
+	// the symmetric key is derived as the peer is added
+	peer := &Peer{
+		ship:        from,
+		sponsor:     sein(from),
+		nextBone:    1,
+		Connections: make(map[int]*Connection),
+		pubKey:      cry,
+		authKey:     sgn,
+		life:        p.SenderLife,
+		resolved:    a.clock.Now(),
+	}
+	name, _ := noun.BN2patp(from)
+	peer, _ = a.addPeer(name, peer)
+	return peer, nil
+}
@@ -0,0 +1,104 @@
+package ames
+
+import (
+	"context"
+	"math/big"
+	mrand "math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+func TestOpenPacket(t *testing.T) {
+	id, err := mineComet(context.Background(), nil, mrand.New(mrand.NewSource(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := OpenPacket{
+		PublicKey:    id.Pass(),
+		Sender:       id.Ship,
+		SenderLife:   1,
+		Receiver:     noun.B(0x10100),
+		ReceiverLife: 2,
+	}
+	_, _, _, _, content, err := DecodePacket(EncodeOpenPacket(p, id.AuthKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1, err := DecodeOpenPacket(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r1.Sender.Cmp(p.Sender) != 0 || r1.Receiver.Cmp(p.Receiver) != 0 || r1.PublicKey.Cmp(p.PublicKey) != 0 {
+		t.Errorf("expected %v got %v", p, r1)
+	}
+	if r1.SenderLife != 1 || r1.ReceiverLife != 2 {
+		t.Errorf("expected %v %v got %v %v", 1, 2, r1.SenderLife, r1.ReceiverLife)
+	}
+
+	// signed by a key other than the one in the pass
+	other, _ := GenerateIdentity(noun.B(0x10200), 1)
+	_, _, _, _, content, _ = DecodePacket(EncodeOpenPacket(p, other.AuthKey))
+	if _, err := DecodeOpenPacket(content); err == nil {
+		t.Errorf("expected a forged signature to fail")
+	}
+	if _, err := DecodeOpenPacket(big.NewInt(12345)); err == nil {
+		t.Errorf("expected garbage to fail")
+	}
+}
+
+func TestHearOpen(t *testing.T) {
+	z := &azimuth{points: make(map[string]LookupResponse)}
+	comet, err := mineComet(context.Background(), nil, mrand.New(mrand.NewSource(4)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	idb, _ := GenerateIdentity(noun.B(0x10200), 1)
+	z.set(idb)
+	a, err := newAmes(comet.Ship, comet.Life, comet.CryptKey, comet.AuthKey, nil, Options{Lookup: z.Lookup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close(context.Background())
+	b, err := newAmes(idb.Ship, idb.Life, idb.CryptKey, idb.AuthKey, nil, Options{Lookup: z.Lookup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close(context.Background())
+
+	// the planet can't look the comet up
+	if _, err := b.GetPeer(comet.Ship); err == nil {
+		t.Errorf("expected an unknown comet to fail")
+	}
+
+	c, err := a.Connect(shipName(b.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pinRelay(c.Peer, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: b.conn.LocalAddr().Port})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = c.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("from a comet"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !b.knowsPeer(comet.Ship) {
+		t.Errorf("expected the comet to be a peer")
+	}
+
+	// an attestation whose keys don't hash to the sender is refused
+	forged := *comet
+	forged.Ship = new(big.Int).Add(comet.Ship, big.NewInt(1))
+	_, _, _, _, content, _ := DecodePacket(EncodeOpenPacket(OpenPacket{
+		PublicKey:  comet.Pass(),
+		Sender:     forged.Ship,
+		SenderLife: 1,
+		Receiver:   b.Ship,
+	}, comet.AuthKey))
+	if _, err := b.hearOpen(forged.Ship, b.Ship, content); err == nil {
+		t.Errorf("expected a mismatched comet name to fail")
+	}
+}
@@ -0,0 +1,312 @@
+package ames
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+var ErrPokeNack = errors.New("poke nacked")
+
+// NackError is the error sent with a nack, a tag and a tang of lines
+type NackError struct {
+	Tag  string
+	Tang []string
+}
+
+func (e *NackError) Error() string {
+	if len(e.Tang) == 0 {
+		return e.Tag
+	}
+	return e.Tag + ": " + strings.Join(e.Tang, "\n")
+}
+
+// Event is a typed plea or boon delivered to OnPacket
+type Event interface {
+	isEvent() bool
+}
+
+// Plea is a request to a gall agent, sent as [%g /ge/app %0 request],
+// or a Cork to ames itself
+type Plea interface {
+	Event
+	isPlea() bool
+}
+
+// Boon is a response on a flow we opened
+type Boon interface {
+	Event
+	isBoon() bool
+}
+
+// Poke is a %m plea
+type Poke struct {
+	App  string
+	Mark string
+	Data noun.Noun
+}
+
+// Watch is a %s plea, subscribing to Path
+type Watch struct {
+	App  string
+	Path []string
+}
+
+// Leave is a %u plea, ending the subscription on the flow
+type Leave struct {
+	App string
+}
+
+// Cork closes the flow it is sent on. It is for ames, not an agent,
+// and is sent as [%$ /flow %cork ~]
+type Cork struct{}
+
+// Fact is a %d boon, a single update sent on a subscription
+type Fact struct {
+	Mark string
+	Data noun.Noun
+}
+
+// Kick is a %x boon, the agent ended the subscription
+type Kick struct{}
+
+// WatchAck is the message ack of a Watch, Err is set on a nack
+type WatchAck struct {
+	Err error
+}
+
+// PokeAck is the message ack of a Poke, Err is set on a nack
+type PokeAck struct {
+	Num int
+	Err error
+}
+
+func (Poke) isEvent() bool     { return true }
+func (Watch) isEvent() bool    { return true }
+func (Leave) isEvent() bool    { return true }
+func (Cork) isEvent() bool     { return true }
+func (Fact) isEvent() bool     { return true }
+func (Kick) isEvent() bool     { return true }
+func (WatchAck) isEvent() bool { return true }
+func (PokeAck) isEvent() bool  { return true }
+
+func (Poke) isPlea() bool  { return true }
+func (Watch) isPlea() bool { return true }
+func (Leave) isPlea() bool { return true }
+func (Cork) isPlea() bool  { return true }
+
+func (Fact) isBoon() bool     { return true }
+func (Kick) isBoon() bool     { return true }
+func (WatchAck) isBoon() bool { return true }
+func (PokeAck) isBoon() bool  { return true }
+
+// EncodePlea builds the noun sent over ames for a plea
+func EncodePlea(p Plea) (noun.Noun, error) {
+	switch t := p.(type) {
+	case Poke:
+		return ConstructPoke(agentPath(t.App), t.Mark, t.Data), nil
+	case Watch:
+		return ConstructWatch(agentPath(t.App), t.Path), nil
+	case Leave:
+		return ConstructLeave(agentPath(t.App)), nil
+	case Cork:
+		return noun.MakeNoun([]interface{}{0, []interface{}{"flow", 0}, "cork", 0}), nil
+	default:
+		return noun.MakeNoun(0), fmt.Errorf("unknown plea %T", p)
+	}
+}
+
+// DecodePlea is the reverse of EncodePlea
+func DecodePlea(n noun.Noun) (Plea, error) {
+	vane, err := cord(noun.Head(n))
+	if err != nil {
+		return nil, err
+	}
+	if vane == "" {
+		return decodeFlowPlea(noun.Tail(n))
+	}
+	if vane != "g" {
+		return nil, fmt.Errorf("unknown vane %s", vane)
+	}
+	path, err := destructPath(noun.Head(noun.Tail(n)))
+	if err != nil {
+		return nil, err
+	}
+	if len(path) != 2 || path[0] != "ge" {
+		return nil, fmt.Errorf("unknown gall path %v", path)
+	}
+	app := path[1]
+
+	// skip the %0 version
+	req := noun.Slag(n, 3)
+	tag, err := cord(noun.Head(req))
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case "m":
+		mark, err := cord(noun.Head(noun.Tail(req)))
+		if err != nil {
+			return nil, err
+		}
+		return Poke{App: app, Mark: mark, Data: noun.Slag(req, 2)}, nil
+	case "s":
+		path, err := destructPath(noun.Tail(req))
+		if err != nil {
+			return nil, err
+		}
+		return Watch{App: app, Path: path}, nil
+	case "u":
+		return Leave{App: app}, nil
+	default:
+		return nil, fmt.Errorf("unknown plea %s", tag)
+	}
+}
+
+// decodeFlowPlea reads the [path payload] of a plea to ames itself
+func decodeFlowPlea(n noun.Noun) (Plea, error) {
+	path, err := destructPath(noun.Head(n))
+	if err != nil {
+		return nil, err
+	}
+	tag, err := cord(noun.Head(noun.Tail(n)))
+	if err != nil {
+		return nil, err
+	}
+	if len(path) != 1 || path[0] != "flow" || tag != "cork" {
+		return nil, fmt.Errorf("unknown ames plea %v %s", path, tag)
+	}
+	return Cork{}, nil
+}
+
+// EncodeBoon builds the noun sent over ames for a boon.
+// WatchAck and PokeAck are not boons on the wire but message acks
+func EncodeBoon(b Boon) (noun.Noun, error) {
+	switch t := b.(type) {
+	case Fact:
+		return noun.MakeNoun([]interface{}{"d", t.Mark, t.Data}), nil
+	case Kick:
+		return noun.MakeNoun([]interface{}{"x", 0}), nil
+	default:
+		return noun.MakeNoun(0), fmt.Errorf("%T is sent as a message ack", b)
+	}
+}
+
+// DecodeBoon is the reverse of EncodeBoon
+func DecodeBoon(n noun.Noun) (Boon, error) {
+	tag, err := cord(noun.Head(n))
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case "d":
+		mark, err := cord(noun.Head(noun.Tail(n)))
+		if err != nil {
+			return nil, err
+		}
+		return Fact{Mark: mark, Data: noun.Tail(noun.Tail(n))}, nil
+	case "x":
+		return Kick{}, nil
+	default:
+		return nil, fmt.Errorf("unknown boon %s", tag)
+	}
+}
+
+// EncodeNaxplanation builds the [message-num tag tang] explaining a
+// nack, sent as its own message on the flow's bone mixed with 2
+func EncodeNaxplanation(num int, err *NackError) noun.Noun {
+	return noun.MakeNoun([]interface{}{num, err.Tag, EncodeTang(err.Tang)})
+}
+
+// DecodeNaxplanation is the reverse of EncodeNaxplanation
+func DecodeNaxplanation(n noun.Noun) (int, *NackError, error) {
+	num, err := noun.AssertAtom(noun.Head(n))
+	if err != nil {
+		return 0, nil, err
+	}
+	tag, err := cord(noun.Head(noun.Tail(n)))
+	if err != nil {
+		return 0, nil, err
+	}
+	tang, err := DecodeTang(noun.Tail(noun.Tail(n)))
+	if err != nil {
+		return 0, nil, err
+	}
+	return int(num.Value.Int64()), &NackError{Tag: tag, Tang: tang}, nil
+}
+
+// EncodeTang builds a tang with a %leaf for each line
+func EncodeTang(lines []string) noun.Noun {
+	tang := noun.MakeNoun(0)
+	for i := len(lines) - 1; i >= 0; i-- {
+		leaf := noun.Cell{Head: noun.MakeNoun("leaf"), Tail: tape(lines[i])}
+		tang = noun.Cell{Head: leaf, Tail: tang}
+	}
+	return tang
+}
+
+// DecodeTang flattens a tang into lines, the children of %rose and
+// %palm tanks are listed in order
+func DecodeTang(n noun.Noun) ([]string, error) {
+	lines := []string{}
+	for cur := n; ; cur = noun.Tail(cur) {
+		if _, ok := cur.(noun.Cell); !ok {
+			return lines, nil
+		}
+		tank := noun.Head(cur)
+		tag, err := cord(noun.Head(tank))
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case "leaf":
+			lines = append(lines, untape(noun.Tail(tank)))
+		case "rose", "palm":
+			sub, err := DecodeTang(noun.Tail(noun.Tail(tank)))
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, sub...)
+		default:
+			return nil, fmt.Errorf("unknown tank %s", tag)
+		}
+	}
+}
+
+// tape is a null terminated list of bytes
+func tape(s string) noun.Noun {
+	t := noun.MakeNoun(0)
+	for i := len(s) - 1; i >= 0; i-- {
+		t = noun.Cell{Head: noun.MakeNoun(int(s[i])), Tail: t}
+	}
+	return t
+}
+
+func untape(n noun.Noun) string {
+	b := []byte{}
+	for cur := n; ; cur = noun.Tail(cur) {
+		if _, ok := cur.(noun.Cell); !ok {
+			return string(b)
+		}
+		c, err := noun.AssertAtom(noun.Head(cur))
+		if err != nil {
+			return string(b)
+		}
+		b = append(b, byte(c.Value.Int64()))
+	}
+}
+
+func agentPath(app string) []string {
+	return []string{"ge", app}
+}
+
+// cord asserts an atom and returns it as a string
+func cord(n noun.Noun) (string, error) {
+	a, err := noun.AssertAtom(n)
+	if err != nil {
+		return "", err
+	}
+	return string(noun.BigToLittle(a.Value)), nil
+}
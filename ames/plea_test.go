@@ -0,0 +1,136 @@
+package ames
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+var pleaFixtures = []struct {
+	plea Plea
+	jam  string
+}{
+	{Poke{App: "hood", Mark: "helm-hi", Data: noun.MakeNoun("ping")}, "5446293427400615627168770935011744630350584192948000500175511867329"},
+	{Watch{App: "chat-store", Path: []string{"updates"}}, "20025649262214063860082987728763148717288364822524140551269304223780801"},
+	{Leave{App: "chat-store"}, "17075734667444896885556110828537794122704834908097"},
+	{Cork{}, "224962804270731551607556128857"}, // (jam [%$ /flow %cork ~])
+}
+
+var boonFixtures = []struct {
+	boon Boon
+	jam  string
+}{
+	{Fact{Mark: "json", Data: noun.MakeNoun("{}")}, "18962626713188159168039361"},
+	{Kick{}, "192961"},
+}
+
+func TestEncodePlea(t *testing.T) {
+	for _, f := range pleaFixtures {
+		n, err := EncodePlea(f.plea)
+		if err != nil {
+			t.Error(err)
+		}
+		r1 := noun.Jam(n).Text(10)
+		if r1 != f.jam {
+			t.Errorf("%T: expected %s got %s", f.plea, f.jam, r1)
+		}
+	}
+}
+
+func TestDecodePlea(t *testing.T) {
+	for _, f := range pleaFixtures {
+		b, _ := noun.B(0).SetString(f.jam, 10)
+		p, err := DecodePlea(noun.Cue(b))
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(p, f.plea) {
+			t.Errorf("expected %v got %v", f.plea, p)
+		}
+	}
+
+	_, err := DecodePlea(noun.MakeNoun([]interface{}{"g", []string{"ge", "hood"}, 0, "z", 0}))
+	if err == nil {
+		t.Errorf("expected an error for an unknown plea")
+	}
+	_, err = DecodePlea(noun.MakeNoun([]interface{}{"c", []string{"ge", "hood"}, 0, "m", 0}))
+	if err == nil {
+		t.Errorf("expected an error for an unknown vane")
+	}
+}
+
+func TestEncodeBoon(t *testing.T) {
+	for _, f := range boonFixtures {
+		n, err := EncodeBoon(f.boon)
+		if err != nil {
+			t.Error(err)
+		}
+		r1 := noun.Jam(n).Text(10)
+		if r1 != f.jam {
+			t.Errorf("%T: expected %s got %s", f.boon, f.jam, r1)
+		}
+	}
+
+	// acks are message acks, not boons
+	for _, b := range []Boon{WatchAck{}, PokeAck{}} {
+		_, err := EncodeBoon(b)
+		if err == nil {
+			t.Errorf("%T: expected an error", b)
+		}
+	}
+}
+
+func TestDecodeBoon(t *testing.T) {
+	for _, f := range boonFixtures {
+		b, _ := noun.B(0).SetString(f.jam, 10)
+		r1, err := DecodeBoon(noun.Cue(b))
+		if err != nil {
+			t.Error(err)
+		}
+		if !reflect.DeepEqual(r1, f.boon) {
+			t.Errorf("expected %v got %v", f.boon, r1)
+		}
+	}
+
+	_, err := DecodeBoon(noun.MakeNoun([]interface{}{"z", 0}))
+	if err == nil {
+		t.Errorf("expected an error for an unknown boon")
+	}
+}
+
+func TestNaxplanation(t *testing.T) {
+	nerr := &NackError{Tag: "poke-fail", Tang: []string{"bad mark", "try again"}}
+	n := EncodeNaxplanation(4, nerr)
+
+	// [4 %poke-fail [%leaf "bad mark"] [%leaf "try again"] ~]
+	if noun.Head(noun.Head(noun.Tail(noun.Tail(n)))).String() != noun.MakeNoun("leaf").String() {
+		t.Errorf("expected a leaf got %v", n)
+	}
+
+	num, r1, err := DecodeNaxplanation(noun.Cue(noun.Jam(n)))
+	if err != nil {
+		t.Error(err)
+	}
+	if num != 4 || !reflect.DeepEqual(r1, nerr) {
+		t.Errorf("expected %v %v got %v %v", 4, nerr, num, r1)
+	}
+	if r1.Error() != "poke-fail: bad mark\ntry again" {
+		t.Errorf("expected %q got %q", "poke-fail: bad mark\ntry again", r1.Error())
+	}
+}
+
+func TestDecodeTang(t *testing.T) {
+	// [%rose [" " "[" "]"] ~[leaf+"a" leaf+"b"]] is flattened
+	leaves := EncodeTang([]string{"a", "b"})
+	rose := noun.MakeNoun([]interface{}{"rose", []interface{}{tape(" "), tape("["), tape("]")}, leaves})
+	tang := noun.MakeNoun([]interface{}{rose, noun.Cell{Head: noun.MakeNoun([]interface{}{"leaf", tape("c")}), Tail: noun.MakeNoun(0)}})
+
+	r1, err := DecodeTang(tang)
+	if err != nil {
+		t.Error(err)
+	}
+	if !reflect.DeepEqual(r1, []string{"a", "b", "c"}) {
+		t.Errorf("expected %v got %v", []string{"a", "b", "c"}, r1)
+	}
+}
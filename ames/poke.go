@@ -0,0 +1,156 @@
+package ames
+
+import (
+	"context"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+// naxplanationTimeout is how long a nack waits for its naxplanation
+// before failing with ErrPokeNack alone
+var naxplanationTimeout = 30 * time.Second
+
+// PokeFuture is the pending ack of a poke
+type PokeFuture struct {
+	Num  int
+	done chan struct{}
+	err  error
+}
+
+// Done is closed once the poke is acked or nacked
+func (f *PokeFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+// Err is nil for an ack or a *NackError with the remote tang, it is only
+// set once Done is closed
+func (f *PokeFuture) Err() error {
+	return f.err
+}
+
+// Wait blocks until the poke is acked or ctx is done
+func (f *PokeFuture) Wait(ctx context.Context) error {
+	select {
+	case <-f.done:
+		return f.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Poke sends a mark and data to the agent at path and waits for the
+// message ack. A nack returns a *NackError carrying the remote tang.
+// If ctx ends first the message is still delivered, but its ack is no
+// longer waited on
+func (c *Connection) Poke(ctx context.Context, path []string, mark string, data noun.Noun) error {
+	f, err := c.PokeAsync(path, mark, data)
+	if err != nil {
+		return err
+	}
+	err = f.Wait(ctx)
+	if err != nil && err == ctx.Err() {
+		c.forget(f)
+	}
+	return err
+}
+
+// forget stops waiting on the ack of f
+func (c *Connection) forget(f *PokeFuture) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.futures[f.Num] == f {
+		delete(c.futures, f.Num)
+	}
+	if timer, ok := c.nacks[f.Num]; ok {
+		timer.Stop()
+		delete(c.nacks, f.Num)
+	}
+}
+
+// PokeAsync sends the poke and returns a future of its ack
+func (c *Connection) PokeAsync(path []string, mark string, data noun.Noun) (*PokeFuture, error) {
+	if c.ames.isClosed() {
+		return nil, ErrClosed
+	}
+	f := &PokeFuture{done: make(chan struct{})}
+	_, pkts, err := c.queue(ConstructPoke(path, mark, data), f)
+	if err != nil {
+		if pkts != nil {
+			c.forget(f)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// onPokeAck resolves an ack straight away. A nack waits for the
+// naxplanation, which is usually sent just before it
+func (c *Connection) onPokeAck(num int, nack bool) {
+	if !nack {
+		c.finishPoke(num, nil)
+		return
+	}
+	c.mut.Lock()
+	nerr, ok := c.naxplanations[num]
+	if ok {
+		delete(c.naxplanations, num)
+	} else if _, waiting := c.nacks[num]; !waiting {
+		var timer *time.Timer
+		timer = time.AfterFunc(naxplanationTimeout, func() {
+			c.mut.Lock()
+			waiting := c.nacks[num] == timer
+			if waiting {
+				delete(c.nacks, num)
+			}
+			c.mut.Unlock()
+			if waiting {
+				c.finishPoke(num, ErrPokeNack)
+			}
+		})
+		c.nacks[num] = timer
+	}
+	c.mut.Unlock()
+
+	if ok {
+		c.finishPoke(num, nerr)
+	}
+}
+
+// onNaxplanation takes the error explaining the nack of message num
+func (c *Connection) onNaxplanation(num int, nerr *NackError) {
+	c.mut.Lock()
+	// watch nacks are already handled by the subscription
+	if c.sub != nil {
+		c.mut.Unlock()
+		return
+	}
+	timer, waiting := c.nacks[num]
+	if waiting {
+		timer.Stop()
+		delete(c.nacks, num)
+	} else {
+		c.naxplanations[num] = nerr
+	}
+	c.mut.Unlock()
+
+	if waiting {
+		c.finishPoke(num, nerr)
+	}
+}
+
+// finishPoke resolves the future of message num and tells OnPacket
+func (c *Connection) finishPoke(num int, err error) {
+	c.mut.Lock()
+	f, ok := c.futures[num]
+	delete(c.futures, num)
+	c.mut.Unlock()
+
+	if ok {
+		f.err = err
+		close(f.done)
+	}
+	if c.ames.OnPacket != nil {
+		c.ames.OnPacket(c, PokeAck{Num: num, Err: err})
+	}
+}
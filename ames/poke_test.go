@@ -0,0 +1,105 @@
+package ames
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+func pokeConnection() *Connection {
+	return &Connection{
+		ames:          &Ames{},
+		futures:       make(map[int]*PokeFuture),
+		nacks:         make(map[int]*time.Timer),
+		naxplanations: make(map[int]*NackError),
+	}
+}
+
+func pokeFuture(c *Connection, num int) *PokeFuture {
+	f := &PokeFuture{Num: num, done: make(chan struct{})}
+	c.futures[num] = f
+	return f
+}
+
+func TestPokeAck(t *testing.T) {
+	c := pokeConnection()
+	f := pokeFuture(c, 1)
+	c.onPokeAck(1, false)
+	err := f.Wait(context.Background())
+	if err != nil {
+		t.Errorf("expected %v got %v", nil, err)
+	}
+}
+
+func TestPokeNack(t *testing.T) {
+	c := pokeConnection()
+	nerr := &NackError{Tag: "poke-fail", Tang: []string{"bad"}}
+
+	// the naxplanation usually comes first
+	f1 := pokeFuture(c, 1)
+	c.onNaxplanation(1, nerr)
+	c.onPokeAck(1, true)
+	err := f1.Wait(context.Background())
+	if err != nerr {
+		t.Errorf("expected %v got %v", nerr, err)
+	}
+
+	f2 := pokeFuture(c, 2)
+	c.onPokeAck(2, true)
+	select {
+	case <-f2.Done():
+		t.Errorf("expected the nack to wait for its naxplanation")
+	default:
+	}
+	c.onNaxplanation(2, nerr)
+	err = f2.Wait(context.Background())
+	var r1 *NackError
+	if !errors.As(err, &r1) || r1.Tag != "poke-fail" {
+		t.Errorf("expected %v got %v", nerr, err)
+	}
+}
+
+func TestPokeNackTimeout(t *testing.T) {
+	timeout := naxplanationTimeout
+	naxplanationTimeout = 10 * time.Millisecond
+	defer func() { naxplanationTimeout = timeout }()
+
+	c := pokeConnection()
+	f := pokeFuture(c, 1)
+	c.onPokeAck(1, true)
+	err := f.Wait(context.Background())
+	if err != ErrPokeNack {
+		t.Errorf("expected %v got %v", ErrPokeNack, err)
+	}
+}
+
+func TestPokeCancel(t *testing.T) {
+	c := pokeConnection()
+	f := pokeFuture(c, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := f.Wait(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v got %v", context.DeadlineExceeded, err)
+	}
+
+	// a Poke that gives up stops waiting on the ack
+	a, b := testPair(t)
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+	ab, _ := a.Connect(shipName(b.Ship))
+	pinRelay(ab.Peer, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1})
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = ab.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("lost"))
+	ab.mut.Lock()
+	futures := len(ab.futures)
+	ab.mut.Unlock()
+	if err != context.DeadlineExceeded || futures != 0 {
+		t.Errorf("expected %v with no futures got %v %v", context.DeadlineExceeded, err, futures)
+	}
+}
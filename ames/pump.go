@@ -0,0 +1,235 @@
+package ames
+
+import (
+	"time"
+)
+
+const (
+	minRTO          = 200 * time.Millisecond
+	maxRTO          = 2 * time.Minute
+	defaultRTT      = time.Second
+	defaultSsthresh = 10000
+	maxLivePackets  = 1000
+)
+
+// clock returns the current time, it is swapped out in tests
+type clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// livePacket is a fragment which has been sent but not yet acked
+type livePacket struct {
+	num, fun int
+	raw      []byte
+	lastSent time.Time
+	tries    int
+}
+
+// pump is the congestion controlled sender for a single flow.
+// It follows the pump-state in ames: slow start until ssthresh then
+// additive increase, multiplicative decrease on timeout
+type pump struct {
+	clock    clock
+	rto      time.Duration
+	rtt      time.Duration
+	rttVar   time.Duration
+	sampled  bool
+	ssthresh int
+	cwnd     int
+	counter  int
+	maxLive  int
+	live     []*livePacket // sent and awaiting ack, ordered by num then fun
+	queue    []*livePacket // waiting for room in the window
+}
+
+func newPump(c clock) *pump {
+	return &pump{
+		clock:    c,
+		rto:      defaultRTT,
+		rtt:      defaultRTT,
+		rttVar:   defaultRTT,
+		ssthresh: defaultSsthresh,
+		cwnd:     1,
+		maxLive:  maxLivePackets,
+	}
+}
+
+// Send queues every fragment of message num
+func (p *pump) Send(num int, pkts [][]byte) {
+	for fun, raw := range pkts {
+		p.queue = append(p.queue, &livePacket{num: num, fun: fun, raw: raw})
+	}
+}
+
+// Replace swaps the fragments of message num for a new encoding of
+// them, keeping their place in the window
+func (p *pump) Replace(num int, pkts [][]byte) {
+	for _, lps := range [][]*livePacket{p.live, p.queue} {
+		for _, lp := range lps {
+			if lp.num == num && lp.fun < len(pkts) {
+				lp.raw = pkts[lp.fun]
+			}
+		}
+	}
+}
+
+// Next moves as many queued fragments into flight as the window allows
+// and returns them to be written to the wire
+func (p *pump) Next() [][]byte {
+	now := p.clock.Now()
+	var out [][]byte
+	for len(p.queue) > 0 && len(p.live) < p.window() {
+		lp := p.queue[0]
+		p.queue = p.queue[1:]
+		lp.lastSent = now
+		lp.tries = 1
+		p.live = append(p.live, lp)
+		out = append(out, lp.raw)
+	}
+	return out
+}
+
+// Retransmits returns every live fragment whose timer has expired.
+// Any expiry counts as a single congestion event for the flow
+func (p *pump) Retransmits() [][]byte {
+	now := p.clock.Now()
+	var out [][]byte
+	for _, lp := range p.live {
+		if now.Before(lp.lastSent.Add(p.backoff(lp.tries))) {
+			continue
+		}
+		lp.lastSent = now
+		lp.tries++
+		out = append(out, lp.raw)
+	}
+	if len(out) > 0 {
+		p.onTimeout()
+	}
+	return out
+}
+
+// Ack removes the acked fragment fun of message num from flight.
+// A fun of -1 is a message ack and clears every fragment of num
+func (p *pump) Ack(num, fun int) {
+	now := p.clock.Now()
+	acked := false
+	live := p.live[:0]
+	for _, lp := range p.live {
+		if lp.num != num || (fun != -1 && lp.fun != fun) {
+			live = append(live, lp)
+			continue
+		}
+		acked = true
+		// only unambiguous samples feed the rtt estimate
+		if lp.tries == 1 {
+			p.onSample(now.Sub(lp.lastSent))
+		}
+	}
+	p.live = live
+
+	if fun == -1 {
+		queue := p.queue[:0]
+		for _, lp := range p.queue {
+			if lp.num != num {
+				queue = append(queue, lp)
+			}
+		}
+		p.queue = queue
+	}
+	if acked {
+		p.onAck()
+	}
+}
+
+// Deadline is the time the next retransmit timer fires
+func (p *pump) Deadline() (time.Time, bool) {
+	if len(p.live) == 0 {
+		return time.Time{}, false
+	}
+	next := p.live[0].lastSent.Add(p.backoff(p.live[0].tries))
+	for _, lp := range p.live[1:] {
+		d := lp.lastSent.Add(p.backoff(lp.tries))
+		if d.Before(next) {
+			next = d
+		}
+	}
+	return next, true
+}
+
+// Pending returns the number of fragments sent or queued but not acked
+func (p *pump) Pending() int {
+	return len(p.live) + len(p.queue)
+}
+
+func (p *pump) window() int {
+	if p.cwnd < p.maxLive {
+		return p.cwnd
+	}
+	return p.maxLive
+}
+
+// backoff doubles the rto for every retry of a fragment
+func (p *pump) backoff(tries int) time.Duration {
+	d := p.rto
+	for i := 1; i < tries && d < maxRTO; i++ {
+		d *= 2
+	}
+	return clampRTO(d)
+}
+
+func (p *pump) onAck() {
+	// slow start
+	if p.cwnd < p.ssthresh {
+		p.cwnd++
+		return
+	}
+	// additive increase, one packet per window
+	p.counter++
+	if p.counter >= p.cwnd {
+		p.cwnd++
+		p.counter = 0
+	}
+}
+
+func (p *pump) onTimeout() {
+	p.ssthresh = p.cwnd / 2
+	if p.ssthresh < 1 {
+		p.ssthresh = 1
+	}
+	p.cwnd = 1
+	p.counter = 0
+}
+
+// onSample updates the rtt estimate as in RFC 6298
+func (p *pump) onSample(sample time.Duration) {
+	if !p.sampled {
+		p.sampled = true
+		p.rtt = sample
+		p.rttVar = sample / 2
+		p.rto = clampRTO(p.rtt + 4*p.rttVar)
+		return
+	}
+	diff := p.rtt - sample
+	if diff < 0 {
+		diff = -diff
+	}
+	p.rttVar = (3*p.rttVar + diff) / 4
+	p.rtt = (7*p.rtt + sample) / 8
+	p.rto = clampRTO(p.rtt + 4*p.rttVar)
+}
+
+func clampRTO(d time.Duration) time.Duration {
+	if d < minRTO {
+		return minRTO
+	}
+	if d > maxRTO {
+		return maxRTO
+	}
+	return d
+}
@@ -0,0 +1,194 @@
+package ames
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (f *fakeClock) Now() time.Time {
+	return f.now
+}
+
+func (f *fakeClock) Advance(d time.Duration) {
+	f.now = f.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1600000000, 0)}
+}
+
+func frags(n int) [][]byte {
+	pkts := [][]byte{}
+	for i := 0; i < n; i++ {
+		pkts = append(pkts, []byte{byte(i)})
+	}
+	return pkts
+}
+
+func TestPumpSlowStart(t *testing.T) {
+	p := newPump(newFakeClock())
+	p.Send(1, frags(10))
+
+	sent := p.Next()
+	if len(sent) != 1 {
+		t.Errorf("expected %v got %v", 1, len(sent))
+	}
+	// every ack grows the window by one during slow start
+	p.Ack(1, 0)
+	if p.cwnd != 2 {
+		t.Errorf("expected %v got %v", 2, p.cwnd)
+	}
+	sent = p.Next()
+	if len(sent) != 2 {
+		t.Errorf("expected %v got %v", 2, len(sent))
+	}
+	p.Ack(1, 1)
+	p.Ack(1, 2)
+	sent = p.Next()
+	if len(sent) != 4 {
+		t.Errorf("expected %v got %v", 4, len(sent))
+	}
+	if p.Pending() != 7 {
+		t.Errorf("expected %v got %v", 7, p.Pending())
+	}
+}
+
+func TestPumpCongestionAvoidance(t *testing.T) {
+	p := newPump(newFakeClock())
+	p.cwnd = 4
+	p.ssthresh = 4
+	p.Send(1, frags(8))
+	p.Next()
+
+	// a full window of acks is needed to grow by one
+	for i := 0; i < 3; i++ {
+		p.Ack(1, i)
+		if p.cwnd != 4 {
+			t.Errorf("expected %v got %v", 4, p.cwnd)
+		}
+	}
+	p.Ack(1, 3)
+	if p.cwnd != 5 {
+		t.Errorf("expected %v got %v", 5, p.cwnd)
+	}
+}
+
+func TestPumpTimeout(t *testing.T) {
+	clk := newFakeClock()
+	p := newPump(clk)
+	p.cwnd = 8
+	p.Send(1, frags(4))
+	p.Next()
+
+	clk.Advance(p.rto - time.Millisecond)
+	if len(p.Retransmits()) != 0 {
+		t.Errorf("expected no retransmits before the rto")
+	}
+	clk.Advance(time.Millisecond)
+	if n := len(p.Retransmits()); n != 4 {
+		t.Errorf("expected %v got %v", 4, n)
+	}
+	// multiplicative decrease
+	if p.cwnd != 1 {
+		t.Errorf("expected %v got %v", 1, p.cwnd)
+	}
+	if p.ssthresh != 4 {
+		t.Errorf("expected %v got %v", 4, p.ssthresh)
+	}
+}
+
+func TestPumpBackoff(t *testing.T) {
+	clk := newFakeClock()
+	p := newPump(clk)
+	p.Send(1, frags(1))
+	p.Next()
+
+	rto := p.rto
+	expected := []time.Duration{rto, rto * 2, rto * 4, rto * 8}
+	for i, d := range expected {
+		clk.Advance(d - time.Millisecond)
+		if len(p.Retransmits()) != 0 {
+			t.Errorf("retry %d: expected no retransmit before %v", i, d)
+		}
+		clk.Advance(time.Millisecond)
+		if len(p.Retransmits()) != 1 {
+			t.Errorf("retry %d: expected retransmit after %v", i, d)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		clk.Advance(maxRTO)
+		p.Retransmits()
+	}
+	if p.backoff(p.live[0].tries) != maxRTO {
+		t.Errorf("expected %v got %v", maxRTO, p.backoff(p.live[0].tries))
+	}
+}
+
+func TestPumpRTT(t *testing.T) {
+	clk := newFakeClock()
+	p := newPump(clk)
+	p.Send(1, frags(3))
+	p.Next()
+	clk.Advance(100 * time.Millisecond)
+	p.Ack(1, 0)
+	if p.rtt != 100*time.Millisecond {
+		t.Errorf("expected %v got %v", 100*time.Millisecond, p.rtt)
+	}
+	if p.rto != 300*time.Millisecond {
+		t.Errorf("expected %v got %v", 300*time.Millisecond, p.rto)
+	}
+
+	p.Next()
+	clk.Advance(20 * time.Millisecond)
+	p.Ack(1, 1)
+	// rtt moves an eighth of the way to the sample, rttvar a quarter
+	if p.rtt != 90*time.Millisecond {
+		t.Errorf("expected %v got %v", 90*time.Millisecond, p.rtt)
+	}
+	if p.rto != 320*time.Millisecond {
+		t.Errorf("expected %v got %v", 320*time.Millisecond, p.rto)
+	}
+
+	// retransmitted fragments are ambiguous and not sampled
+	clk.Advance(p.rto)
+	p.Retransmits()
+	clk.Advance(5 * time.Second)
+	p.Ack(1, 2)
+	if p.rtt != 90*time.Millisecond {
+		t.Errorf("expected %v got %v", 90*time.Millisecond, p.rtt)
+	}
+}
+
+func TestPumpMessageAck(t *testing.T) {
+	p := newPump(newFakeClock())
+	p.cwnd = 2
+	p.Send(1, frags(4))
+	p.Send(2, frags(1))
+	p.Next()
+
+	p.Ack(1, -1)
+	if p.Pending() != 1 {
+		t.Errorf("expected %v got %v", 1, p.Pending())
+	}
+	sent := p.Next()
+	if len(sent) != 1 {
+		t.Errorf("expected %v got %v", 1, len(sent))
+	}
+}
+
+func TestPumpMaxLive(t *testing.T) {
+	p := newPump(newFakeClock())
+	p.cwnd = 50
+	p.maxLive = 10
+	p.Send(1, frags(20))
+	if n := len(p.Next()); n != 10 {
+		t.Errorf("expected %v got %v", 10, n)
+	}
+	if _, ok := p.Deadline(); !ok {
+		t.Errorf("expected a deadline with live packets")
+	}
+}
@@ -0,0 +1,85 @@
+package ames
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+// rekeyGrace is how long packets to our previous life are still read
+// after Rekey, while peers catch up with the new one
+var rekeyGrace = 10 * time.Minute
+
+// Rekey switches to the keys of a new life, as after |moon-cycle-keys.
+// Messages from the next one on are encrypted with the new keys, those
+// already in flight keep the old ones. Packets sent to our previous
+// life are accepted for a grace window
+func (a *Ames) Rekey(newSeed string) error {
+	id, err := NewKeyfileIdentity(newSeed)
+	if err != nil {
+		return err
+	}
+	if id.Ship.Cmp(a.Ship) != 0 {
+		return errors.New("seed is for " + shipName(id.Ship) + " not " + shipName(a.Ship))
+	}
+	a.keyMut.Lock()
+	// peers are added under keyMut, none can be missed
+	peers := a.peers()
+	if id.Life <= a.Life {
+		a.keyMut.Unlock()
+		return fmt.Errorf("life %d is not after our life %d", id.Life, a.Life)
+	}
+	a.prevLife = a.Life
+	a.prevUntil = a.clock.Now().Add(rekeyGrace)
+	a.PrivateKey = id.CryptKey
+	a.authKey = id.AuthKey
+	a.Life = id.Life
+	for _, p := range peers {
+		p.keyMut.Lock()
+		p.prevSymKey = p.symKey
+		if p.pubKey != [32]byte{} {
+			p.symKey = urcrypt.UrcryptEdShar(p.pubKey, id.CryptKey)
+		}
+		p.keyMut.Unlock()
+	}
+	a.keyMut.Unlock()
+
+	a.dirty.Store(true)
+	a.logger().Info("rekeyed", "life", id.Life)
+	return nil
+}
+
+func (a *Ames) life() int64 {
+	a.keyMut.RLock()
+	defer a.keyMut.RUnlock()
+	return a.Life
+}
+
+// ourKeys returns our encryption and signing seeds and our life
+func (a *Ames) ourKeys() ([32]byte, [32]byte, int64) {
+	a.keyMut.RLock()
+	defer a.keyMut.RUnlock()
+	return a.PrivateKey, a.authKey, a.Life
+}
+
+// inboundKeys returns the symmetric key, peer life and our life to read
+// a packet sent to our life tick. In the grace window after Rekey that
+// may be our previous life
+func (a *Ames) inboundKeys(p *Peer, toTick int64) ([]byte, int64, int64) {
+	a.keyMut.RLock()
+	ourLife := a.Life
+	prev := toTick != ourLife%16 && toTick == a.prevLife%16 && a.clock.Now().Before(a.prevUntil)
+	if prev {
+		ourLife = a.prevLife
+	}
+	a.keyMut.RUnlock()
+
+	p.keyMut.RLock()
+	defer p.keyMut.RUnlock()
+	if prev {
+		return p.prevSymKey, p.life, ourLife
+	}
+	return p.symKey, p.life, ourLife
+}
@@ -0,0 +1,163 @@
+package ames
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+// azimuth is a fake lookup of the keys of a few identities
+type azimuth struct {
+	mut    sync.Mutex
+	points map[string]LookupResponse
+}
+
+func (z *azimuth) set(id *Identity) {
+	z.mut.Lock()
+	defer z.mut.Unlock()
+	enc := urcrypt.UrcryptEdPuck(id.CryptKey)
+	auth := urcrypt.UrcryptEdPuck(id.AuthKey)
+	z.points[shipName(id.Ship)] = LookupResponse{
+		EncryptionKey:     noun.LittleToBig(enc[:]).Text(16),
+		AuthenticationKey: noun.LittleToBig(auth[:]).Text(16),
+		Life:              id.Life,
+	}
+}
+
+func (z *azimuth) Lookup(name string) (LookupResponse, error) {
+	z.mut.Lock()
+	defer z.mut.Unlock()
+	return z.points[name], nil
+}
+
+func TestRekey(t *testing.T) {
+	defer func(d time.Duration) { resolveInterval = d }(resolveInterval)
+	resolveInterval = 0
+	z := &azimuth{points: make(map[string]LookupResponse)}
+	ida, _ := GenerateIdentity(noun.B(0x10100), 1)
+	idb, _ := GenerateIdentity(noun.B(0x10200), 1)
+	z.set(ida)
+	z.set(idb)
+	a, err := newAmes(ida.Ship, ida.Life, ida.CryptKey, ida.AuthKey, nil, Options{Lookup: z.Lookup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close(context.Background())
+	b, err := newAmes(idb.Ship, idb.Life, idb.CryptKey, idb.AuthKey, nil, Options{Lookup: z.Lookup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close(context.Background())
+	connect := func(x, y *Ames) *Connection {
+		c, err := x.Connect(shipName(y.Ship))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pinRelay(c.Peer, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: y.conn.LocalAddr().Port})
+		return c
+	}
+	ab := connect(a, b)
+	ba := connect(b, a)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = ab.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("life 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id2, _ := GenerateIdentity(ida.Ship, 2)
+	err = a.Rekey(id2.Keyfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.set(id2)
+
+	// b still sends to life 1, which is read in the grace window, and
+	// learns of life 2 from the ack
+	err = ba.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("to life 1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ba.Peer.Life() != 2 {
+		t.Errorf("expected %v got %v", 2, ba.Peer.Life())
+	}
+	err = ab.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("life 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// after the grace window only the new life is read
+	a.keyMut.Lock()
+	a.prevUntil = time.Time{}
+	a.keyMut.Unlock()
+	_, _, r1 := a.inboundKeys(ab.Peer, 1)
+	if r1 != 2 {
+		t.Errorf("expected %v got %v", 2, r1)
+	}
+
+	if a.Rekey(id2.Keyfile()) == nil {
+		t.Errorf("expected the same life to fail")
+	}
+	if a.Rekey(idb.Keyfile()) == nil {
+		t.Errorf("expected another ship's seed to fail")
+	}
+}
+
+// TestRekeyConcurrentPeers rekeys while peers are being added, every
+// peer must end up with a key for the new life
+func TestRekeyConcurrentPeers(t *testing.T) {
+	z := &azimuth{points: make(map[string]LookupResponse)}
+	ida, _ := GenerateIdentity(noun.B(0x10100), 1)
+	var ships []*big.Int
+	for i := int64(0); i < 64; i++ {
+		id, _ := GenerateIdentity(noun.B(0x20100+i<<16), 1)
+		z.set(id)
+		ships = append(ships, id.Ship)
+	}
+	a, err := newAmes(ida.Ship, ida.Life, ida.CryptKey, ida.AuthKey, nil, Options{Lookup: z.Lookup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close(context.Background())
+
+	// a peer looked up before the rekey and added after it, as GetPeer
+	// may do
+	early, err := a.newPeer(ships[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, _ := GenerateIdentity(ida.Ship, 2)
+	var wg sync.WaitGroup
+	for _, ship := range ships[1:] {
+		wg.Add(1)
+		go func(ship *big.Int) {
+			defer wg.Done()
+			_, err := a.GetPeer(ship)
+			if err != nil {
+				t.Error(err)
+			}
+		}(ship)
+	}
+	err = a.Rekey(id2.Keyfile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.addPeer(shipName(ships[0]), early)
+	wg.Wait()
+
+	for _, p := range a.peers() {
+		p.keyMut.RLock()
+		symKey, expected := p.symKey, urcrypt.UrcryptEdShar(p.pubKey, id2.CryptKey)
+		p.keyMut.RUnlock()
+		if string(symKey) != string(expected) {
+			t.Errorf("expected %v to have a key for life 2", shipName(p.ship))
+		}
+	}
+}
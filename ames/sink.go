@@ -0,0 +1,159 @@
+package ames
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+// maxPendingMessages is how far past last-acked a message num may be
+// before its fragments are dropped, matching ames
+const maxPendingMessages = 10
+
+// sinkAck is an ack the sink wants sent, fun of -1 is a message ack
+type sinkAck struct {
+	num, fun int
+}
+
+// sinkMessage is a complete message ready to hand to the application
+type sinkMessage struct {
+	num int
+	msg noun.Noun
+}
+
+// sink is the receiving side of a flow. It reassembles fragments and
+// releases each message exactly once, in order of message num. A
+// message is acked once Done says it was handled
+type sink struct {
+	lastAcked int                     // handled and acked
+	lastHeard int                     // released, lastAcked up to it are being handled
+	partial   map[int]*partialMessage // incomplete messages by num
+	pending   map[int]noun.Noun       // complete messages waiting on an earlier num
+	nax       map[int]bool            // recently delivered messages that were nacked
+}
+
+// partialMessage is the fragments heard of a message, by fragment num
+type partialMessage struct {
+	total int // fragment count given by the first fragment
+	frags map[int]noun.Noun
+}
+
+func newSink() *sink {
+	return &sink{
+		partial: make(map[int]*partialMessage),
+		pending: make(map[int]noun.Noun),
+		nax:     make(map[int]bool),
+	}
+}
+
+// Done marks message num handled so it can be acked. Messages are done
+// in the order they were released
+func (s *sink) Done(num int) {
+	s.lastAcked = num
+	delete(s.nax, num-maxPendingMessages)
+}
+
+// Nack marks a delivered message as nacked so its acks, including
+// re-acks of duplicates, are nacks. Like ames only the last
+// maxPendingMessages nacks are kept, older duplicates are acked
+func (s *sink) Nack(num int) {
+	if num > s.lastAcked-maxPendingMessages {
+		s.nax[num] = true
+	}
+}
+
+func (s *sink) Nacked(num int) bool {
+	return s.nax[num]
+}
+
+// Hear takes a fragment meat [num-fragments fragment-num fragment] of
+// message num and returns the fragment acks to send and messages to
+// handle
+func (s *sink) Hear(num int, meat noun.Noun) ([]sinkAck, []sinkMessage, error) {
+	total, fun, err := fragmentMeta(meat)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// already delivered, re-ack so the sender stops retrying
+	if num <= s.lastAcked {
+		return []sinkAck{{num, -1}}, nil, nil
+	}
+	// still being handled, acked once it is done
+	if num <= s.lastHeard {
+		return nil, nil, nil
+	}
+	if num > s.lastHeard+maxPendingMessages {
+		return nil, nil, nil
+	}
+	// already complete and waiting for the gap before it
+	if _, ok := s.pending[num]; ok {
+		return []sinkAck{{num, fun}}, nil, nil
+	}
+
+	part, ok := s.partial[num]
+	if !ok {
+		part = &partialMessage{total: total, frags: make(map[int]noun.Noun)}
+		s.partial[num] = part
+	}
+	if total != part.total {
+		return nil, nil, errors.New("fragment count differs from the message's")
+	}
+	part.frags[fun] = meat
+	if len(part.frags) < total {
+		return []sinkAck{{num, fun}}, nil, nil
+	}
+
+	msg, err := JoinMessage(sortFragments(part.frags))
+	delete(s.partial, num)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.pending[num] = msg
+
+	var msgs []sinkMessage
+	for {
+		next, ok := s.pending[s.lastHeard+1]
+		if !ok {
+			break
+		}
+		s.lastHeard++
+		delete(s.pending, s.lastHeard)
+		msgs = append(msgs, sinkMessage{s.lastHeard, next})
+	}
+	// the last fragment of a released message is acked with it
+	if len(msgs) == 0 {
+		return []sinkAck{{num, fun}}, nil, nil
+	}
+	return nil, msgs, nil
+}
+
+// fragmentMeta returns the fragment count and fragment num of a meat
+func fragmentMeta(meat noun.Noun) (int, int, error) {
+	total, err := noun.AssertAtom(noun.Head(meat))
+	if err != nil {
+		return 0, 0, err
+	}
+	fun, err := noun.AssertAtom(noun.Head(noun.Tail(meat)))
+	if err != nil {
+		return 0, 0, err
+	}
+	if fun.Value.Cmp(total.Value) >= 0 {
+		return 0, 0, errors.New("fragment num out of range")
+	}
+	return int(total.Value.Int64()), int(fun.Value.Int64()), nil
+}
+
+func sortFragments(frags map[int]noun.Noun) []noun.Noun {
+	funs := []int{}
+	for fun := range frags {
+		funs = append(funs, fun)
+	}
+	sort.Ints(funs)
+	sorted := []noun.Noun{}
+	for _, fun := range funs {
+		sorted = append(sorted, frags[fun])
+	}
+	return sorted
+}
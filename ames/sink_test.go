@@ -0,0 +1,169 @@
+package ames
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+func fragMeats(num int, n noun.Noun) []noun.Noun {
+	meats := []noun.Noun{}
+	for _, frag := range SplitMessage(num, n) {
+		meats = append(meats, noun.Tail(frag))
+	}
+	return meats
+}
+
+func TestSinkInOrder(t *testing.T) {
+	s := newSink()
+	poke := ConstructPoke([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("one"))
+
+	// the message ack waits for it to be handled
+	acks, msgs, err := s.Hear(1, fragMeats(1, poke)[0])
+	if err != nil {
+		t.Error(err)
+	}
+	if len(acks) != 0 {
+		t.Errorf("expected no acks got %v", acks)
+	}
+	if len(msgs) != 1 || msgs[0].msg.String() != poke.String() {
+		t.Errorf("expected %v got %v", poke, msgs)
+	}
+}
+
+func TestSinkDuplicate(t *testing.T) {
+	s := newSink()
+	poke := ConstructPoke([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("one"))
+	meat := fragMeats(1, poke)[0]
+	s.Hear(1, meat)
+
+	// a retransmit while it is handled is dropped
+	acks, msgs, _ := s.Hear(1, meat)
+	if len(acks) != 0 || len(msgs) != 0 {
+		t.Errorf("expected nothing got %v %v", acks, msgs)
+	}
+	s.Done(1)
+
+	// and once done is re-acked but not delivered again
+	acks, msgs, _ = s.Hear(1, meat)
+	if !reflect.DeepEqual(acks, []sinkAck{{1, -1}}) {
+		t.Errorf("expected %v got %v", []sinkAck{{1, -1}}, acks)
+	}
+	if len(msgs) != 0 {
+		t.Errorf("expected no messages got %v", msgs)
+	}
+}
+
+func TestSinkOutOfOrder(t *testing.T) {
+	s := newSink()
+	p1 := ConstructPoke([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("one"))
+	p2 := ConstructPoke([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("two"))
+	p3 := ConstructPoke([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("three"))
+
+	acks, msgs, _ := s.Hear(3, fragMeats(3, p3)[0])
+	if !reflect.DeepEqual(acks, []sinkAck{{3, 0}}) {
+		t.Errorf("expected %v got %v", []sinkAck{{3, 0}}, acks)
+	}
+	if len(msgs) != 0 {
+		t.Errorf("expected no messages got %v", msgs)
+	}
+	s.Hear(2, fragMeats(2, p2)[0])
+
+	acks, msgs, _ = s.Hear(1, fragMeats(1, p1)[0])
+	if len(acks) != 0 {
+		t.Errorf("expected no acks got %v", acks)
+	}
+	if len(msgs) != 3 {
+		t.Errorf("expected %v got %v", 3, len(msgs))
+	}
+	for k, p := range []noun.Noun{p1, p2, p3} {
+		if msgs[k].num != k+1 || msgs[k].msg.String() != p.String() {
+			t.Errorf("expected %v got %v", p, msgs[k].msg)
+		}
+	}
+}
+
+func TestSinkFragments(t *testing.T) {
+	s := newSink()
+	// make a message larger than one fragment
+	large := noun.B(0).Exp(noun.B(2), noun.B(20000), nil)
+	poke := ConstructPoke([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun(large))
+	meats := fragMeats(1, poke)
+	if len(meats) != 3 {
+		t.Errorf("expected %v got %v", 3, len(meats))
+	}
+
+	acks, msgs, _ := s.Hear(1, meats[2])
+	if !reflect.DeepEqual(acks, []sinkAck{{1, 2}}) || len(msgs) != 0 {
+		t.Errorf("expected %v got %v", []sinkAck{{1, 2}}, acks)
+	}
+	s.Hear(1, meats[0])
+	acks, msgs, _ = s.Hear(1, meats[1])
+	if len(acks) != 0 {
+		t.Errorf("expected no acks got %v", acks)
+	}
+	if len(msgs) != 1 || msgs[0].msg.String() != poke.String() {
+		t.Errorf("expected %v got %v", poke, msgs)
+	}
+}
+
+func TestSinkTooFarAhead(t *testing.T) {
+	s := newSink()
+	poke := ConstructPoke([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("one"))
+	num := maxPendingMessages + 1
+	acks, msgs, _ := s.Hear(num, fragMeats(num, poke)[0])
+	if len(acks) != 0 || len(msgs) != 0 {
+		t.Errorf("expected message %d to be dropped", num)
+	}
+}
+
+func TestSinkNack(t *testing.T) {
+	s := newSink()
+	poke := ConstructPoke([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("one"))
+	meat := fragMeats(1, poke)[0]
+	s.Hear(1, meat)
+	s.Nack(1)
+	s.Done(1)
+
+	// a retransmit of a nacked message is nacked again
+	acks, _, _ := s.Hear(1, meat)
+	if !reflect.DeepEqual(acks, []sinkAck{{1, -1}}) || !s.Nacked(1) {
+		t.Errorf("expected %v nacked got %v", []sinkAck{{1, -1}}, acks)
+	}
+}
+
+func TestSinkNackPruned(t *testing.T) {
+	s := newSink()
+	poke := ConstructPoke([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("one"))
+	for num := 1; num <= 3*maxPendingMessages; num++ {
+		s.Hear(num, fragMeats(num, poke)[0])
+		s.Nack(num)
+		s.Done(num)
+	}
+	if len(s.nax) != maxPendingMessages {
+		t.Errorf("expected %v got %v", maxPendingMessages, len(s.nax))
+	}
+	if s.Nacked(1) || !s.Nacked(3*maxPendingMessages) {
+		t.Errorf("expected only recent nacks kept got %v", s.nax)
+	}
+}
+
+func TestSinkFragmentCount(t *testing.T) {
+	s := newSink()
+	large := noun.B(0).Exp(noun.B(2), noun.B(20000), nil)
+	meats := fragMeats(1, ConstructPoke([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun(large)))
+	s.Hear(1, meats[0])
+
+	// a fragment claiming another count is dropped
+	bad := noun.MakeNoun([]interface{}{4, 3, 1})
+	_, msgs, err := s.Hear(1, bad)
+	if err == nil || len(msgs) != 0 {
+		t.Errorf("expected an error got %v %v", msgs, err)
+	}
+	s.Hear(1, meats[1])
+	_, msgs, err = s.Hear(1, meats[2])
+	if err != nil || len(msgs) != 1 {
+		t.Errorf("expected the message got %v %v", msgs, err)
+	}
+}
@@ -0,0 +1,278 @@
+package ames
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+// galaxies listen on galaxyPort plus their number
+const galaxyPort = 13337
+
+var defaultGalaxyTemplate = "%s.urbit.org:%d"
+
+const (
+	galaxyRank = iota
+	starRank
+	planetRank
+	moonRank
+	cometRank
+)
+
+// rank is the class of a ship by its size in bytes
+func rank(ship *big.Int) int {
+	switch n := (ship.BitLen() + 7) / 8; {
+	case n <= 1:
+		return galaxyRank
+	case n == 2:
+		return starRank
+	case n <= 4:
+		return planetRank
+	case n <= 8:
+		return moonRank
+	default:
+		return cometRank
+	}
+}
+
+// sein is the default sponsor of a ship, comets are sponsored by the
+// star in their low bits
+func sein(ship *big.Int) *big.Int {
+	if rank(ship) == cometRank {
+		return noun.Cut(0, 16, ship)
+	}
+	name, err := noun.BN2patp(ship)
+	if err != nil {
+		return noun.B(0)
+	}
+	parent, err := noun.Sein(name)
+	if err != nil {
+		return noun.B(0)
+	}
+	return parent
+}
+
+// azimuthSponsor is the sponsor on azimuth, which differs from sein once
+// a point has escaped. Moons and comets aren't on azimuth
+func azimuthSponsor(ship *big.Int, res LookupResponse) *big.Int {
+	r := rank(ship)
+	if r == galaxyRank || r >= moonRank || !res.HasSponsor {
+		return sein(ship)
+	}
+	s, ok := noun.B(0).SetString(res.Sponsor, 16)
+	// a sponsor is always of a higher class
+	if !ok || rank(s) >= r {
+		return sein(ship)
+	}
+	return s
+}
+
+// sponsorOf returns the sponsor of ship as we last looked it up. A ship
+// we don't know is looked up in the background, and until then sein,
+// its sponsor unless it escaped, stands in. guessed reports that
+func (a *Ames) sponsorOf(ship *big.Int) (sponsor *big.Int, guessed bool) {
+	if rank(ship) >= moonRank {
+		return sein(ship), false
+	}
+	if !a.knowsPeer(ship) {
+		a.learn(ship)
+		return sein(ship), true
+	}
+	peer, err := a.GetPeer(ship)
+	if err != nil || peer.sponsor == nil {
+		return sein(ship), false
+	}
+	return peer.sponsor, false
+}
+
+// lookupSponsor is sponsorOf waiting for the lookup
+func (a *Ames) lookupSponsor(ship *big.Int) (*big.Int, error) {
+	if rank(ship) >= moonRank {
+		return sein(ship), nil
+	}
+	peer, err := a.GetPeer(ship)
+	// a point that was never keyed can't have escaped
+	if errors.Is(err, ErrNotFound) {
+		return sein(ship), nil
+	}
+	if err != nil {
+		return noun.B(0), err
+	}
+	if peer.sponsor == nil {
+		return sein(ship), nil
+	}
+	return peer.sponsor, nil
+}
+
+// SponsorChain returns ship followed by each of its sponsors, ending
+// with its galaxy, waiting for the lookup of any we don't know
+func (a *Ames) SponsorChain(ship *big.Int) ([]*big.Int, error) {
+	chain := []*big.Int{ship}
+	for rank(ship) != galaxyRank {
+		s, err := a.lookupSponsor(ship)
+		if err != nil {
+			return chain, err
+		}
+		ship = s
+		chain = append(chain, ship)
+	}
+	return chain, nil
+}
+
+// cachedChain is SponsorChain without waiting, see sponsorOf. guessed
+// reports whether sein stood in for any sponsor
+func (a *Ames) cachedChain(ship *big.Int) (chain []*big.Int, guessed bool) {
+	chain = []*big.Int{ship}
+	for rank(ship) != galaxyRank {
+		s, g := a.sponsorOf(ship)
+		guessed = guessed || g
+		ship = s
+		chain = append(chain, ship)
+	}
+	return chain, guessed
+}
+
+// relayTimeout is how long a galaxy's address is used before it is
+// resolved again, in case its DNS has moved
+var relayTimeout = 10 * time.Minute
+
+// galaxyLane is a galaxy's resolved address and when it expires
+type galaxyLane struct {
+	addr  *net.UDPAddr
+	until time.Time
+}
+
+// checkGalaxyTemplate makes sure a template gives a galaxy's host and
+// port, Sprintf doesn't fail on a missing or mistyped verb
+func checkGalaxyTemplate(tpl string) error {
+	if tpl == "" {
+		return nil
+	}
+	hostport := fmt.Sprintf(tpl, "zod", galaxyPort)
+	_, port, err := net.SplitHostPort(hostport)
+	if strings.Contains(hostport, "%!") || err != nil || port != strconv.Itoa(galaxyPort) {
+		return fmt.Errorf("galaxy template %q must give host:port with the port as %%d or %%[2]d, got %q", tpl, hostport)
+	}
+	return nil
+}
+
+// galaxyAddr resolves a galaxy from the static map or the DNS template
+func (a *Ames) galaxyAddr(galaxy *big.Int) (*net.UDPAddr, error) {
+	name, err := noun.BN2patp(galaxy)
+	if err != nil {
+		return nil, err
+	}
+	now := a.clock.Now()
+	a.galaxyMut.Lock()
+	gl, ok := a.galaxies[name]
+	a.galaxyMut.Unlock()
+	if ok && now.Before(gl.until) {
+		return gl.addr, nil
+	}
+
+	hostport, ok := a.opts.Galaxies[name]
+	if !ok {
+		tpl := a.opts.GalaxyTemplate
+		if tpl == "" {
+			tpl = defaultGalaxyTemplate
+		}
+		hostport = fmt.Sprintf(tpl, strings.TrimPrefix(name, "~"), galaxyPort+int(galaxy.Int64()))
+	}
+	addr, err := net.ResolveUDPAddr("udp", hostport)
+	if err != nil {
+		return nil, err
+	}
+
+	a.galaxyMut.Lock()
+	if a.galaxies == nil {
+		a.galaxies = make(map[string]galaxyLane)
+	}
+	a.galaxies[name] = galaxyLane{addr, now.Add(relayTimeout)}
+	a.galaxyMut.Unlock()
+	return addr, nil
+}
+
+// relay returns the address of the peer's galaxy, which forwards our
+// packets until we have a direct lane. Finding it may take a lookup and
+// DNS, too slow for the send path, so once it expires it is resolved in
+// the background and the old one used meanwhile. Before the first one
+// is found the packet is dropped with ErrLookingUp, the pump sends it
+// again
+func (a *Ames) relay(peer *Peer) (*net.UDPAddr, error) {
+	peer.laneMut.Lock()
+	addr, until := peer.relay, peer.relayUntil
+	peer.laneMut.Unlock()
+	if addr != nil && a.clock.Now().Before(until) {
+		return addr, nil
+	}
+	a.resolveRelayAsync(peer)
+	if addr == nil {
+		return nil, ErrLookingUp
+	}
+	return addr, nil
+}
+
+// resolveRelayAsync runs resolveRelay in the background, one at a time
+// per peer
+func (a *Ames) resolveRelayAsync(peer *Peer) {
+	if !peer.relaying.CompareAndSwap(false, true) {
+		return
+	}
+	started := a.spawn(func() {
+		defer peer.relaying.Store(false)
+		_, err := a.resolveRelay(peer)
+		if err != nil {
+			a.fault("relay", peer.ship, err)
+		}
+	})
+	if !started {
+		peer.relaying.Store(false)
+	}
+}
+
+// resolveRelay finds the peer's galaxy and keeps its address until
+// relayTimeout, or resolveInterval if a sponsor was guessed while it is
+// looked up. If that fails the old address is kept and tried again
+// after resolveInterval
+func (a *Ames) resolveRelay(peer *Peer) (*net.UDPAddr, error) {
+	chain, guessed := a.cachedChain(peer.ship)
+	addr, err := a.galaxyAddr(chain[len(chain)-1])
+	if err != nil {
+		peer.laneMut.Lock()
+		if peer.relay != nil {
+			peer.relayUntil = a.clock.Now().Add(resolveInterval)
+		}
+		peer.laneMut.Unlock()
+		return nil, err
+	}
+	ttl := relayTimeout
+	if guessed {
+		ttl = resolveInterval
+	}
+	peer.laneMut.Lock()
+	peer.relay = addr
+	peer.relayUntil = a.clock.Now().Add(ttl)
+	peer.laneMut.Unlock()
+	return addr, nil
+}
+
+// expireRelay makes the next send resolve the peer's galaxy again,
+// after sending to it failed
+func (a *Ames) expireRelay(peer *Peer, addr *net.UDPAddr) {
+	peer.laneMut.Lock()
+	peer.relayUntil = time.Time{}
+	peer.laneMut.Unlock()
+	a.galaxyMut.Lock()
+	for name, gl := range a.galaxies {
+		if gl.addr.String() == addr.String() {
+			delete(a.galaxies, name)
+		}
+	}
+	a.galaxyMut.Unlock()
+}
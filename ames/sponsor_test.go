@@ -0,0 +1,255 @@
+package ames
+
+import (
+	"context"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+func TestSein(t *testing.T) {
+	ships := []*big.Int{
+		noun.B(0x1),
+		noun.B(0x201),
+		noun.B(0x10201),
+		noun.B(0x7e7100010201),
+		noun.B(0).Lsh(noun.B(1), 100),
+	}
+	expected := []int64{0x1, 0x1, 0x201, 0x10201, 0x0}
+	for i, ship := range ships {
+		r1 := sein(ship)
+		if r1.Int64() != expected[i] {
+			t.Errorf("expected %x got %x", expected[i], r1)
+		}
+	}
+}
+
+func TestAzimuthSponsor(t *testing.T) {
+	planet := noun.B(0x10201)
+
+	// an escaped planet follows azimuth
+	r1 := azimuthSponsor(planet, LookupResponse{Sponsor: "0300", HasSponsor: true})
+	if r1.Int64() != 0x300 {
+		t.Errorf("expected %x got %x", 0x300, r1)
+	}
+
+	r2 := azimuthSponsor(planet, LookupResponse{Sponsor: "0300"})
+	if r2.Int64() != 0x201 {
+		t.Errorf("expected %x got %x", 0x201, r2)
+	}
+
+	// a sponsor of the same class is ignored
+	r3 := azimuthSponsor(planet, LookupResponse{Sponsor: "10300", HasSponsor: true})
+	if r3.Int64() != 0x201 {
+		t.Errorf("expected %x got %x", 0x201, r3)
+	}
+
+	// moons aren't on azimuth
+	moon := noun.B(0x7e7100010201)
+	r4 := azimuthSponsor(moon, LookupResponse{Sponsor: "0300", HasSponsor: true})
+	if r4.Int64() != 0x10201 {
+		t.Errorf("expected %x got %x", 0x10201, r4)
+	}
+}
+
+func TestSponsorChain(t *testing.T) {
+	a := &Ames{Peers: make(map[string]*Peer)}
+	add := func(ship, sponsor int64) {
+		patp, _ := noun.BN2patp(noun.B(ship))
+		a.Peers[patp] = &Peer{ship: noun.B(ship), sponsor: noun.B(sponsor)}
+	}
+	// the planet and its star have both escaped
+	add(0x10201, 0x300)
+	add(0x300, 0x2)
+
+	chain, err := a.SponsorChain(noun.B(0x7e7100010201))
+	if err != nil {
+		t.Error(err)
+	}
+	expected := []int64{0x7e7100010201, 0x10201, 0x300, 0x2}
+	if len(chain) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, chain)
+	}
+	for i, ship := range chain {
+		if ship.Int64() != expected[i] {
+			t.Errorf("expected %x got %x", expected[i], ship)
+		}
+	}
+}
+
+// TestSponsorOf guesses the sponsor of a ship we don't know while it
+// is looked up in the background
+func TestSponsorOf(t *testing.T) {
+	z := &azimuth{points: make(map[string]LookupResponse)}
+	id, _ := GenerateIdentity(noun.B(0x10201), 1)
+	z.set(id)
+	// ~doznec-marzod has escaped to ~wanzod
+	point := z.points[shipName(id.Ship)]
+	point.HasSponsor, point.Sponsor = true, "300"
+	z.points[shipName(id.Ship)] = point
+	a, err := newAmes(noun.B(0x10100), 1, [32]byte{}, [32]byte{}, nil, Options{Lookup: z.Lookup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close(context.Background())
+
+	s1, guessed := a.sponsorOf(id.Ship)
+	if !guessed || s1.Int64() != 0x201 {
+		t.Errorf("expected a guess of %x got %x %v", 0x201, s1, guessed)
+	}
+	for i := 0; i < 100 && !a.knowsPeer(id.Ship); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s2, guessed := a.sponsorOf(id.Ship)
+	if guessed || s2.Int64() != 0x300 {
+		t.Errorf("expected %x got %x %v", 0x300, s2, guessed)
+	}
+}
+
+func TestGalaxyAddr(t *testing.T) {
+	clock := newFakeClock()
+	a := &Ames{clock: clock, opts: Options{
+		GalaxyTemplate: "127.0.0.1:%[2]d",
+		Galaxies:       map[string]string{"~nec": "127.0.0.2:4000"},
+	}}
+
+	r1, err := a.galaxyAddr(noun.B(2))
+	if err != nil {
+		t.Error(err)
+	}
+	if r1.String() != "127.0.0.1:13339" {
+		t.Errorf("expected %v got %v", "127.0.0.1:13339", r1)
+	}
+
+	r2, err := a.galaxyAddr(noun.B(1))
+	if err != nil {
+		t.Error(err)
+	}
+	if r2.String() != "127.0.0.2:4000" {
+		t.Errorf("expected %v got %v", "127.0.0.2:4000", r2)
+	}
+
+	// an expired address is resolved again
+	a.opts.Galaxies["~nec"] = "127.0.0.3:4000"
+	r3, _ := a.galaxyAddr(noun.B(1))
+	clock.Advance(relayTimeout)
+	r4, _ := a.galaxyAddr(noun.B(1))
+	if r3.String() != "127.0.0.2:4000" || r4.String() != "127.0.0.3:4000" {
+		t.Errorf("expected %v then %v got %v %v", "127.0.0.2:4000", "127.0.0.3:4000", r3, r4)
+	}
+}
+
+func TestGalaxyTemplate(t *testing.T) {
+	for tpl, ok := range map[string]bool{
+		"":                  true,
+		"%s.urbit.org:%d":   true,
+		"127.0.0.1:%[2]d":   true,
+		"%s.urbit.org":      false,
+		"%s.urbit.org:%s":   false,
+		"galaxy.local:%d":   false,
+		"%s.urbit.org:4000": false,
+	} {
+		if err := checkGalaxyTemplate(tpl); (err == nil) != ok {
+			t.Errorf("%q: expected ok %v got %v", tpl, ok, err)
+		}
+	}
+	_, err := newAmes(noun.B(0x10100), 1, [32]byte{}, [32]byte{}, nil, Options{GalaxyTemplate: "%s.urbit.org"})
+	if err == nil {
+		t.Errorf("expected a template without a port to fail")
+	}
+}
+
+func TestRelayExpires(t *testing.T) {
+	clock := newFakeClock()
+	a := &Ames{Ship: noun.B(0x10100), Peers: make(map[string]*Peer), clock: clock, opts: Options{
+		Galaxies: map[string]string{"~zod": "127.0.0.2:4000"},
+	}}
+	// the first send only starts finding the relay
+	peer := &Peer{ship: noun.B(0)}
+	_, err := a.relay(peer)
+	if err != ErrLookingUp {
+		t.Errorf("expected %v got %v", ErrLookingUp, err)
+	}
+	a.wg.Wait()
+	r1, err := a.relay(peer)
+	if err != nil || r1.String() != "127.0.0.2:4000" {
+		t.Errorf("expected %v got %v %v", "127.0.0.2:4000", r1, err)
+	}
+
+	// an expired relay is still used while the new one is found
+	a.opts.Galaxies["~zod"] = "127.0.0.3:4000"
+	a.expireRelay(peer, r1)
+	r2, _ := a.relay(peer)
+	a.wg.Wait()
+	r3, _ := a.relay(peer)
+	if r2.String() != "127.0.0.2:4000" || r3.String() != "127.0.0.3:4000" {
+		t.Errorf("expected %v then %v got %v %v", "127.0.0.2:4000", "127.0.0.3:4000", r2, r3)
+	}
+
+	// a galaxy that no longer resolves leaves the old address in use
+	a.opts.Galaxies["~zod"] = "not a lane"
+	clock.Advance(relayTimeout)
+	a.relay(peer)
+	a.wg.Wait()
+	r4, err := a.relay(peer)
+	if err != nil || r4.String() != "127.0.0.3:4000" {
+		t.Errorf("expected %v got %v %v", "127.0.0.3:4000", r4, err)
+	}
+
+	// a peer added without a sponsor falls back to sein, ~marzod's
+	// galaxy is ~zod
+	a.opts.Galaxies["~zod"] = "127.0.0.4:4000"
+	marzod := &Peer{ship: noun.B(0x100)}
+	a.Peers["~marzod"] = marzod
+	a.relay(marzod)
+	a.wg.Wait()
+	r5, err := a.relay(marzod)
+	if err != nil || r5.String() != "127.0.0.4:4000" {
+		t.Errorf("expected %v got %v %v", "127.0.0.4:4000", r5, err)
+	}
+}
+
+// TestSendWithoutRelay sends on a direct lane without finding the
+// relay, and doesn't wait for a slow lookup without one
+func TestSendWithoutRelay(t *testing.T) {
+	looked := make(chan struct{}, 1)
+	release := make(chan struct{})
+	a, b := testPairOptions(t, Options{
+		Galaxies: map[string]string{"~zod": "127.0.0.1:1"},
+		Lookup: func(name string) (LookupResponse, error) {
+			select {
+			case looked <- struct{}{}:
+			default:
+			}
+			<-release
+			return LookupResponse{}, ErrNotFound
+		},
+	})
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+	defer close(release)
+
+	// finding the relay of a peer we haven't looked up means a lookup
+	peer := &Peer{ship: noun.B(0x20100), Connections: make(map[int]*Connection)}
+	peer.learnLane(&net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: b.conn.LocalAddr().Port}, nil, a.clock.Now())
+	err := a.sendTo(peer, []byte{1, 2, 3, 4})
+	if err != nil || peer.relaying.Load() {
+		t.Errorf("expected a direct send got %v %v", err, peer.relaying.Load())
+	}
+
+	peer.lane = lane{}
+	done := make(chan error, 1)
+	go func() { done <- a.sendTo(peer, []byte{1, 2, 3, 4}) }()
+	select {
+	case err = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected sendTo not to wait on the lookup")
+	}
+	if err != ErrLookingUp {
+		t.Errorf("expected %v got %v", ErrLookingUp, err)
+	}
+	<-looked
+}
@@ -0,0 +1,286 @@
+package ames
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of our counters, see Ames.Stats
+type Stats struct {
+	Time time.Time // when the snapshot was taken
+	// ChecksumFailures counts packets dropped as corrupt, before their
+	// sender could be known
+	ChecksumFailures uint64
+	Peers            []PeerStats // ordered by ship
+}
+
+// Counters are kept for each peer and each of its flows. A packet sent
+// on both a direct lane and a relay is counted once
+type Counters struct {
+	PacketsSent     uint64
+	PacketsReceived uint64
+	Retransmits     uint64
+	BytesSent       uint64
+	BytesReceived   uint64
+}
+
+// PeerStats is a peer's counters, which include packets that belong to
+// no flow such as scry requests and attestations, and a summary of its
+// flows
+type PeerStats struct {
+	Ship string
+	Counters
+	DecryptFailures uint64
+	RTT             time.Duration // mean of the flows' estimates
+	Pending         int           // messages sent and not yet acked
+	LastAck         time.Time     // zero until an ack is heard
+	Flows           []FlowStats   // ordered by bone
+}
+
+// FlowStats is one flow's counters
+type FlowStats struct {
+	Bone int
+	Counters
+	RTT     time.Duration // smoothed estimate, zero until sampled
+	Pending int
+	LastAck time.Time
+}
+
+// counters are the live Counters, updated without a lock
+type counters struct {
+	packetsSent     atomic.Uint64
+	packetsReceived atomic.Uint64
+	retransmits     atomic.Uint64
+	bytesSent       atomic.Uint64
+	bytesReceived   atomic.Uint64
+}
+
+func (s *counters) sent(n int) {
+	s.packetsSent.Add(1)
+	s.bytesSent.Add(uint64(n))
+}
+
+func (s *counters) received(n int) {
+	s.packetsReceived.Add(1)
+	s.bytesReceived.Add(uint64(n))
+}
+
+func (s *counters) snapshot() Counters {
+	return Counters{
+		PacketsSent:     s.packetsSent.Load(),
+		PacketsReceived: s.packetsReceived.Load(),
+		Retransmits:     s.retransmits.Load(),
+		BytesSent:       s.bytesSent.Load(),
+		BytesReceived:   s.bytesReceived.Load(),
+	}
+}
+
+// received counts a packet heard on the flow
+func (c *Connection) received(n int) {
+	c.stats.received(n)
+	c.Peer.stats.received(n)
+}
+
+// countFault counts a packet we couldn't read against its sender
+func (a *Ames) countFault(err error) {
+	if errors.Is(err, ErrChecksum) {
+		a.badChecksums.Add(1)
+		return
+	}
+	var f *Fault
+	if !errors.As(err, &f) || f.Op != "decrypt" || f.Ship == "" {
+		return
+	}
+	a.peerMut.RLock()
+	p, ok := a.Peers[f.Ship]
+	a.peerMut.RUnlock()
+	if ok {
+		p.badDecrypts.Add(1)
+	}
+}
+
+// Stats takes a snapshot of the counters of every peer and flow
+func (a *Ames) Stats() Stats {
+	s := Stats{Time: a.clock.Now(), ChecksumFailures: a.badChecksums.Load()}
+	for _, p := range a.peers() {
+		ps := PeerStats{
+			Ship:            shipName(p.ship),
+			Counters:        p.stats.snapshot(),
+			DecryptFailures: p.badDecrypts.Load(),
+		}
+		p.mut.Lock()
+		conns := make([]*Connection, 0, len(p.Connections))
+		for _, c := range p.Connections {
+			conns = append(conns, c)
+		}
+		p.mut.Unlock()
+
+		var rtt time.Duration
+		sampled := 0
+		for _, c := range conns {
+			fs := c.flowStats()
+			ps.Flows = append(ps.Flows, fs)
+			ps.Pending += fs.Pending
+			if fs.LastAck.After(ps.LastAck) {
+				ps.LastAck = fs.LastAck
+			}
+			if fs.RTT > 0 {
+				rtt += fs.RTT
+				sampled++
+			}
+		}
+		if sampled > 0 {
+			ps.RTT = rtt / time.Duration(sampled)
+		}
+		sort.Slice(ps.Flows, func(i, j int) bool { return ps.Flows[i].Bone < ps.Flows[j].Bone })
+		s.Peers = append(s.Peers, ps)
+	}
+	sort.Slice(s.Peers, func(i, j int) bool { return s.Peers[i].Ship < s.Peers[j].Ship })
+	return s
+}
+
+func (c *Connection) flowStats() FlowStats {
+	fs := FlowStats{Bone: c.bone, Counters: c.stats.snapshot()}
+	if t := c.lastAck.Load(); t != 0 {
+		fs.LastAck = time.Unix(0, t)
+	}
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	if c.pump.sampled {
+		fs.RTT = c.pump.rtt
+	}
+	fs.Pending = len(c.msgs)
+	return fs
+}
+
+// MetricsHandler serves Stats in the Prometheus text format
+func (a *Ames) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		a.Stats().WritePrometheus(w)
+	})
+}
+
+var counterMetrics = []struct {
+	name, help string
+	value      func(Counters) uint64
+}{
+	{"packets_sent_total", "Packets sent.", func(c Counters) uint64 { return c.PacketsSent }},
+	{"packets_received_total", "Packets heard.", func(c Counters) uint64 { return c.PacketsReceived }},
+	{"retransmits_total", "Fragments sent again after their timer expired.", func(c Counters) uint64 { return c.Retransmits }},
+	{"bytes_sent_total", "Bytes sent.", func(c Counters) uint64 { return c.BytesSent }},
+	{"bytes_received_total", "Bytes heard.", func(c Counters) uint64 { return c.BytesReceived }},
+}
+
+// WritePrometheus writes the snapshot in the Prometheus text format.
+// Peers are labelled by ship and flows by ship and bone
+func (s Stats) WritePrometheus(w io.Writer) error {
+	m := &metricWriter{w: w}
+	m.family("ames_checksum_failures_total", "counter", "Packets dropped for a bad checksum.")
+	m.sample("", float64(s.ChecksumFailures))
+	m.family("ames_peers", "gauge", "Peers we have keys for.")
+	m.sample("", float64(len(s.Peers)))
+
+	for _, c := range counterMetrics {
+		m.family("ames_peer_"+c.name, "counter", c.help)
+		for _, p := range s.Peers {
+			m.sample(peerLabels(p), float64(c.value(p.Counters)))
+		}
+		m.family("ames_flow_"+c.name, "counter", c.help)
+		for _, p := range s.Peers {
+			for _, f := range p.Flows {
+				m.sample(flowLabels(p, f), float64(c.value(f.Counters)))
+			}
+		}
+	}
+
+	m.family("ames_peer_decrypt_failures_total", "counter", "Packets from the peer that failed to decrypt.")
+	for _, p := range s.Peers {
+		m.sample(peerLabels(p), float64(p.DecryptFailures))
+	}
+
+	m.family("ames_peer_rtt_seconds", "gauge", "Mean round trip time estimate of the peer's flows.")
+	for _, p := range s.Peers {
+		if p.RTT > 0 {
+			m.sample(peerLabels(p), p.RTT.Seconds())
+		}
+	}
+	m.family("ames_flow_rtt_seconds", "gauge", "Smoothed round trip time estimate.")
+	for _, p := range s.Peers {
+		for _, f := range p.Flows {
+			if f.RTT > 0 {
+				m.sample(flowLabels(p, f), f.RTT.Seconds())
+			}
+		}
+	}
+
+	m.family("ames_peer_messages_pending", "gauge", "Messages sent and not yet acked.")
+	for _, p := range s.Peers {
+		m.sample(peerLabels(p), float64(p.Pending))
+	}
+	m.family("ames_flow_messages_pending", "gauge", "Messages sent and not yet acked.")
+	for _, p := range s.Peers {
+		for _, f := range p.Flows {
+			m.sample(flowLabels(p, f), float64(f.Pending))
+		}
+	}
+
+	m.family("ames_peer_seconds_since_last_ack", "gauge", "Time since an ack was last heard from the peer.")
+	for _, p := range s.Peers {
+		if !p.LastAck.IsZero() {
+			m.sample(peerLabels(p), s.Time.Sub(p.LastAck).Seconds())
+		}
+	}
+	m.family("ames_flow_seconds_since_last_ack", "gauge", "Time since an ack was last heard on the flow.")
+	for _, p := range s.Peers {
+		for _, f := range p.Flows {
+			if !f.LastAck.IsZero() {
+				m.sample(flowLabels(p, f), s.Time.Sub(f.LastAck).Seconds())
+			}
+		}
+	}
+	return m.err
+}
+
+// metricWriter writes metric families, keeping the first error
+type metricWriter struct {
+	w    io.Writer
+	name string
+	err  error
+}
+
+func (m *metricWriter) family(name, typ, help string) {
+	m.name = name
+	m.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (m *metricWriter) sample(labels string, v float64) {
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	m.printf("%s%s %s\n", m.name, labels, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (m *metricWriter) printf(format string, args ...any) {
+	if m.err != nil {
+		return
+	}
+	_, m.err = fmt.Fprintf(m.w, format, args...)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func peerLabels(p PeerStats) string {
+	return `peer="` + labelEscaper.Replace(p.Ship) + `"`
+}
+
+func flowLabels(p PeerStats, f FlowStats) string {
+	return peerLabels(p) + `,bone="` + strconv.Itoa(f.Bone) + `"`
+}
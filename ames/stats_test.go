@@ -0,0 +1,126 @@
+package ames
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+func TestStats(t *testing.T) {
+	offline := func(string) (LookupResponse, error) { return LookupResponse{}, errors.New("offline") }
+	a, b := recordedPair(t, Options{Lookup: offline})
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+	c, err := a.Connect(shipName(b.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = c.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := a.Stats()
+	if len(s.Peers) != 1 || s.Peers[0].Ship != shipName(b.Ship) {
+		t.Fatalf("expected %v got %v", shipName(b.Ship), s.Peers)
+	}
+	p := s.Peers[0]
+	if p.PacketsSent == 0 || p.PacketsReceived == 0 || p.BytesSent == 0 || p.BytesReceived == 0 {
+		t.Errorf("expected packets both ways got %v", p.Counters)
+	}
+	if p.Pending != 0 || p.LastAck.IsZero() || p.RTT <= 0 {
+		t.Errorf("expected the poke acked got %v", p)
+	}
+	if len(p.Flows) != 1 || p.Flows[0].Bone != c.bone || p.Flows[0].PacketsSent == 0 {
+		t.Errorf("expected the flow on bone %v got %v", c.bone, p.Flows)
+	}
+
+	// a corrupt packet from b, then one under the wrong key
+	x := b.opts.Transport
+	bc, _ := b.Connect(shipName(a.Ship))
+	pkts, err := bc.CreateMessage([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad := append([]byte{}, pkts[0]...)
+	bad[len(bad)-1] ^= 0xff
+	x.WriteTo(bad, a.conn.LocalAddr())
+	pat := FragmentToShutPacket(SplitMessage(9, noun.MakeNoun(0))[0], 1)
+	pack, _ := EncodeShutPacket(pat, []byte("the wrong key"), b.Ship, a.Ship, b.Life, a.Life)
+	x.WriteTo(EncodePacket(pack), a.conn.LocalAddr())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s = a.Stats()
+		if s.ChecksumFailures == 1 && s.Peers[0].DecryptFailures == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.ChecksumFailures != 1 || s.Peers[0].DecryptFailures != 1 {
+		t.Errorf("expected one of each failure got %v %v", s.ChecksumFailures, s.Peers[0].DecryptFailures)
+	}
+
+	rec := httptest.NewRecorder()
+	a.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("expected the text format got %v", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "ames_checksum_failures_total 1\n") {
+		t.Errorf("expected the checksum failure in %v", rec.Body.String())
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := Stats{
+		Time:             now,
+		ChecksumFailures: 3,
+		Peers: []PeerStats{{
+			Ship:            "~zod",
+			Counters:        Counters{PacketsSent: 5, BytesSent: 500},
+			DecryptFailures: 1,
+			RTT:             250 * time.Millisecond,
+			LastAck:         now.Add(-2 * time.Second),
+			Flows: []FlowStats{
+				{Bone: 1, Counters: Counters{PacketsSent: 4}, RTT: 250 * time.Millisecond, Pending: 2, LastAck: now.Add(-2 * time.Second)},
+				{Bone: 5, Counters: Counters{PacketsSent: 1}},
+			},
+		}},
+	}
+	var buf bytes.Buffer
+	err := s.WritePrometheus(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		"# TYPE ames_checksum_failures_total counter\names_checksum_failures_total 3\n",
+		"# TYPE ames_peer_packets_sent_total counter\names_peer_packets_sent_total{peer=\"~zod\"} 5\n",
+		"ames_flow_packets_sent_total{peer=\"~zod\",bone=\"1\"} 4\names_flow_packets_sent_total{peer=\"~zod\",bone=\"5\"} 1\n",
+		"ames_peer_bytes_sent_total{peer=\"~zod\"} 500\n",
+		"ames_peer_decrypt_failures_total{peer=\"~zod\"} 1\n",
+		"ames_peer_rtt_seconds{peer=\"~zod\"} 0.25\n",
+		"ames_flow_messages_pending{peer=\"~zod\",bone=\"1\"} 2\n",
+		"ames_flow_seconds_since_last_ack{peer=\"~zod\",bone=\"1\"} 2\n",
+	} {
+		if !strings.Contains(out, line) {
+			t.Errorf("expected %q in %v", line, out)
+		}
+	}
+	// flows without a sample or an ack have no value to give
+	if strings.Contains(out, `ames_flow_rtt_seconds{peer="~zod",bone="5"}`) || strings.Contains(out, `ames_flow_seconds_since_last_ack{peer="~zod",bone="5"}`) {
+		t.Errorf("expected no rtt or ack age for bone 5 in %v", out)
+	}
+	if peerLabels(PeerStats{Ship: "a\"b\\c\n"}) != `peer="a\"b\\c\n"` {
+		t.Errorf("expected label values escaped")
+	}
+}
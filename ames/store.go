@@ -0,0 +1,445 @@
+package ames
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+// Store persists flow state so a restart resumes the same flows
+// instead of breaching
+type Store interface {
+	// Load returns the saved state, or nil if nothing was saved
+	Load() (*State, error)
+	Save(state *State) error
+}
+
+// Journal is a Store that can also record a change to one flow without
+// writing the whole State. Each send and each delivered message is
+// appended, and the next Save replaces them
+type Journal interface {
+	Store
+	// Append records flow of ship. Num and LastAcked only grow, and
+	// Pending holds messages sent since the last Save
+	Append(ship string, flow FlowState) error
+}
+
+// State is everything needed to resume our flows
+type State struct {
+	Ship  string
+	Life  int64
+	Rift  int64
+	Peers []PeerState
+}
+
+// PeerState is a peer's flows along with the keys it had, so a restart
+// needs no lookups. Keys are hex as in a LookupResponse
+type PeerState struct {
+	Ship              string
+	NextBone          int
+	Life              int64
+	Rift              int64
+	EncryptionKey     string
+	AuthenticationKey string
+	Sponsor           string
+	Flows             []FlowState
+}
+
+// FlowState is one bone. Num is the next message num we send,
+// LastAcked the last one we heard and Pending our unacked messages
+type FlowState struct {
+	Bone      int
+	Num       int
+	LastAcked int
+	Pending   []PendingMessage
+}
+
+// PendingMessage is a sent message that has not been acked, jammed
+type PendingMessage struct {
+	Num int
+	Jam []byte
+}
+
+// FileStore keeps the state as json in a single file, with changes
+// since the last Save appended to Path+".log"
+type FileStore struct {
+	Path string
+	mut  sync.Mutex
+	log  *os.File // open for appending, guarded by mut
+}
+
+// journalEntry is one line of the log
+type journalEntry struct {
+	Ship string
+	Flow FlowState
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+func (s *FileStore) Load() (*State, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &State{}
+	err = json.Unmarshal(b, state)
+	if err != nil {
+		return nil, err
+	}
+	return state, s.replay(state)
+}
+
+// replay applies the log to state
+func (s *FileStore) replay(state *State) error {
+	f, err := os.Open(s.logPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		var e journalEntry
+		err = dec.Decode(&e)
+		if err != nil {
+			// io.EOF, or the last line cut short by a crash
+			return nil
+		}
+		state.apply(e.Ship, e.Flow)
+	}
+}
+
+// Append writes the change as a line of the log and syncs it
+func (s *FileStore) Append(ship string, flow FlowState) error {
+	b, err := json.Marshal(journalEntry{ship, flow})
+	if err != nil {
+		return err
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.log == nil {
+		s.log, err = os.OpenFile(s.logPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = s.log.Write(append(b, '\n'))
+	if err == nil {
+		err = s.log.Sync()
+	}
+	return err
+}
+
+func (s *FileStore) logPath() string {
+	return s.Path + ".log"
+}
+
+// apply merges a journalled change to ship's flow into the state
+func (state *State) apply(ship string, flow FlowState) {
+	var ps *PeerState
+	for i := range state.Peers {
+		if state.Peers[i].Ship == ship {
+			ps = &state.Peers[i]
+		}
+	}
+	if ps == nil {
+		state.Peers = append(state.Peers, PeerState{Ship: ship, NextBone: 1})
+		ps = &state.Peers[len(state.Peers)-1]
+	}
+	// bones we open are 1 mod 4
+	if flow.Bone%4 == 1 && flow.Bone >= ps.NextBone {
+		ps.NextBone = flow.Bone + 4
+	}
+	var fs *FlowState
+	for i := range ps.Flows {
+		if ps.Flows[i].Bone == flow.Bone {
+			fs = &ps.Flows[i]
+		}
+	}
+	if fs == nil {
+		ps.Flows = append(ps.Flows, FlowState{Bone: flow.Bone})
+		fs = &ps.Flows[len(ps.Flows)-1]
+	}
+	fs.Num = max(fs.Num, flow.Num)
+	fs.LastAcked = max(fs.LastAcked, flow.LastAcked)
+	for _, m := range flow.Pending {
+		known := false
+		for _, p := range fs.Pending {
+			known = known || p.Num == m.Num
+		}
+		if !known {
+			fs.Pending = append(fs.Pending, m)
+		}
+	}
+}
+
+// Save writes to a temporary file and renames it so a crash never
+// leaves a partial state
+func (s *FileStore) Save(state *State) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	tmp, err := os.CreateTemp(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	err = os.Rename(tmp.Name(), s.Path)
+	if err != nil {
+		return err
+	}
+	// the state now has everything in the log
+	if s.log != nil {
+		s.log.Close()
+		s.log = nil
+	}
+	err = os.Remove(s.logPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// save writes the current state to the store, if there is one
+func (a *Ames) save() error {
+	if a.opts.Store == nil {
+		return nil
+	}
+	a.saveMut.Lock()
+	defer a.saveMut.Unlock()
+	a.dirty.Store(false)
+	err := a.opts.Store.Save(a.snapshot())
+	if err != nil {
+		a.dirty.Store(true)
+	}
+	return err
+}
+
+// saveSent records message num before it is sent, so a restart can't
+// reuse a num the peer has already seen or lose a message it is waiting
+// for
+func (a *Ames) saveSent(c *Connection, num int, msg noun.Noun) error {
+	return a.journal(c, FlowState{
+		Bone:    c.bone,
+		Num:     num + 1,
+		Pending: []PendingMessage{{num, noun.BigToLittle(noun.Jam(msg))}},
+	})
+}
+
+// saveAcked records the last message we heard on the flow before it is
+// acked, as the peer forgets a message once it hears the ack
+func (a *Ames) saveAcked(c *Connection, lastAcked int) {
+	err := a.journal(c, FlowState{Bone: c.bone, LastAcked: lastAcked})
+	if err != nil {
+		a.fault("save", c.Peer.ship, err)
+	}
+}
+
+// journal appends a change to one flow if the store can, or saves
+// everything. The next tick saves everything either way, which empties
+// the journal
+func (a *Ames) journal(c *Connection, flow FlowState) error {
+	if a.opts.Store == nil {
+		return nil
+	}
+	j, ok := a.opts.Store.(Journal)
+	if !ok {
+		return a.save()
+	}
+	a.saveMut.Lock()
+	err := j.Append(shipName(c.Peer.ship), flow)
+	a.saveMut.Unlock()
+	a.dirty.Store(true)
+	return err
+}
+
+// snapshot builds the State of every flow
+func (a *Ames) snapshot() *State {
+	state := &State{Ship: shipName(a.Ship), Life: a.life(), Rift: a.opts.Rift}
+	for _, p := range a.peers() {
+		ps := PeerState{Ship: shipName(p.ship)}
+		if p.sponsor != nil {
+			ps.Sponsor = shipName(p.sponsor)
+		}
+		p.keyMut.RLock()
+		ps.Life, ps.Rift = p.life, p.rift
+		if p.pubKey != ([32]byte{}) {
+			ps.EncryptionKey = keyHex(p.pubKey)
+			ps.AuthenticationKey = keyHex(p.authKey)
+		}
+		p.keyMut.RUnlock()
+		p.mut.Lock()
+		ps.NextBone = p.nextBone
+		conns := []*Connection{}
+		for _, c := range p.Connections {
+			conns = append(conns, c)
+		}
+		p.mut.Unlock()
+
+		for _, c := range conns {
+			c.mut.Lock()
+			fs := FlowState{Bone: c.bone, Num: c.num, LastAcked: c.sink.lastAcked}
+			for num, msg := range c.msgs {
+				fs.Pending = append(fs.Pending, PendingMessage{num, noun.BigToLittle(noun.Jam(msg))})
+			}
+			c.mut.Unlock()
+			sort.Slice(fs.Pending, func(i, j int) bool { return fs.Pending[i].Num < fs.Pending[j].Num })
+			ps.Flows = append(ps.Flows, fs)
+		}
+		sort.Slice(ps.Flows, func(i, j int) bool { return ps.Flows[i].Bone < ps.Flows[j].Bone })
+		state.Peers = append(state.Peers, ps)
+	}
+	sort.Slice(state.Peers, func(i, j int) bool { return state.Peers[i].Ship < state.Peers[j].Ship })
+	return state
+}
+
+// restore loads the saved flows. State for another ship or rift is
+// ignored, as is everything when we breach, since peers reset our flows
+// after a breach. A new life is only a rekey and keeps the flows
+func (a *Ames) restore() error {
+	if a.opts.Store == nil {
+		return nil
+	}
+	state, err := a.opts.Store.Load()
+	if err != nil {
+		return err
+	}
+	if state != nil && state.Ship == shipName(a.Ship) && state.Rift == a.opts.Rift && !a.breach {
+		err = a.restoreState(state)
+		if err != nil {
+			return err
+		}
+	}
+	// there is always a state for the journal to follow
+	return a.save()
+}
+
+// restoreState rebuilds peers from their saved keys without a lookup.
+// They are looked up again lazily, as any peer is, once their packets
+// stop decrypting. Peers saved without keys are restored once something
+// else looks them up
+func (a *Ames) restoreState(state *State) error {
+	for _, ps := range state.Peers {
+		ship, err := noun.Patp2bn(ps.Ship)
+		if err != nil {
+			return err
+		}
+		a.peerMut.Lock()
+		peer, ok := a.Peers[ps.Ship]
+		if !ok && ps.EncryptionKey == "" {
+			if a.restored == nil {
+				a.restored = make(map[string]PeerState)
+			}
+			a.restored[ps.Ship] = ps
+		}
+		a.peerMut.Unlock()
+		if !ok && ps.EncryptionKey != "" {
+			peer, _ = a.addPeer(ps.Ship, a.savedPeer(ship, ps))
+		}
+		if peer == nil {
+			continue
+		}
+		err = a.restoreFlows(peer, ps)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyHex is the hex keyFromHex reads back
+func keyHex(key [32]byte) string {
+	// LittleToBig reverses in place, key is a copy
+	return noun.LittleToBig(key[:]).Text(16)
+}
+
+// savedPeer builds a peer from its saved keys
+func (a *Ames) savedPeer(ship *big.Int, ps PeerState) *Peer {
+	peer := &Peer{
+		ship:        ship,
+		sponsor:     sein(ship),
+		nextBone:    1,
+		Connections: make(map[int]*Connection),
+	}
+	if ps.Sponsor != "" {
+		if sponsor, err := noun.Patp2bn(ps.Sponsor); err == nil {
+			peer.sponsor = sponsor
+		}
+	}
+	a.setKeys(peer, LookupResponse{
+		EncryptionKey:     ps.EncryptionKey,
+		AuthenticationKey: ps.AuthenticationKey,
+		Life:              ps.Life,
+		Rift:              ps.Rift,
+	})
+	// never looked up, so the first packet we can't read may look it up
+	peer.resolved = time.Time{}
+	return peer
+}
+
+// restoreFlows puts back the saved flows with peer
+func (a *Ames) restoreFlows(peer *Peer, ps PeerState) error {
+	for _, fs := range ps.Flows {
+		peer.mut.Lock()
+		c, ok := peer.Connections[fs.Bone]
+		if !ok {
+			c = a.newConnection(peer, fs.Bone)
+		}
+		peer.mut.Unlock()
+		c.mut.Lock()
+		c.num = max(c.num, fs.Num)
+		c.sink.lastAcked = max(c.sink.lastAcked, fs.LastAcked)
+		c.sink.lastHeard = max(c.sink.lastHeard, c.sink.lastAcked)
+		// resent by the pump once it runs
+		for _, m := range fs.Pending {
+			msg, err := noun.SafeCue(noun.LittleToBig(append([]byte{}, m.Jam...)))
+			if err != nil {
+				c.mut.Unlock()
+				return err
+			}
+			pkts, err := c.encodeMessage(m.Num, msg)
+			if err != nil {
+				c.mut.Unlock()
+				return err
+			}
+			c.msgs[m.Num] = msg
+			c.pump.Send(m.Num, pkts)
+		}
+		c.mut.Unlock()
+	}
+	peer.mut.Lock()
+	if ps.NextBone > peer.nextBone {
+		peer.nextBone = ps.NextBone
+	}
+	peer.mut.Unlock()
+	return nil
+}
@@ -0,0 +1,401 @@
+package ames
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+	"github.com/stevelacy/go-urbit/urcrypt"
+)
+
+func TestFileStore(t *testing.T) {
+	s := NewFileStore(filepath.Join(t.TempDir(), "ames.json"))
+	r0, err := s.Load()
+	if err != nil || r0 != nil {
+		t.Errorf("expected %v got %v %v", nil, r0, err)
+	}
+
+	state := &State{
+		Ship: "~sampel-palnet",
+		Life: 2,
+		Peers: []PeerState{{
+			Ship:     "~zod",
+			NextBone: 9,
+			Flows: []FlowState{{
+				Bone:      5,
+				Num:       3,
+				LastAcked: 1,
+				Pending:   []PendingMessage{{2, []byte{1, 2, 3}}},
+			}},
+		}},
+	}
+	err = s.Save(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r1, state) {
+		t.Errorf("expected %v got %v", state, r1)
+	}
+}
+
+func TestStoreRestore(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "ames.json"))
+	a, b := testPairOptions(t, Options{Store: store})
+	defer b.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	name, _ := noun.BN2patp(b.Ship)
+	c, err := a.Connect(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Close(context.Background())
+
+	state, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Peers) != 1 || len(state.Peers[0].Flows) != 1 {
+		t.Fatalf("expected one flow got %v", state)
+	}
+	r0 := state.Peers[0].Flows[0]
+	if r0.Bone != c.bone || r0.Num != 2 || len(r0.Pending) != 0 {
+		t.Errorf("expected %v got %v", FlowState{Bone: c.bone, Num: 2}, r0)
+	}
+
+	// an unacked message is sent again after the restart
+	msg := ConstructPoke([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("again"))
+	state.Peers[0].Flows[0].Num = 3
+	state.Peers[0].Flows[0].Pending = []PendingMessage{{2, noun.BigToLittle(noun.Jam(msg))}}
+	err = store.Save(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a2, err := newAmes(a.Ship, a.Life, [32]byte{}, [32]byte{}, nil, Options{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a2.Close(context.Background())
+	introduce(a2, b)
+	err = a2.restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := a2.GetConnection(b.Ship, c.bone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2.mut.Lock()
+	num, pending := c2.num, c2.pump.Pending()
+	c2.mut.Unlock()
+	if num != 3 || pending == 0 {
+		t.Errorf("expected num 3 with pending frags got %v %v", num, pending)
+	}
+	if c2.Peer.nextBone <= c.bone {
+		t.Errorf("expected next bone after %v got %v", c.bone, c2.Peer.nextBone)
+	}
+
+	// a new life is only a rekey, the flows are kept
+	state.Life = 5
+	store.Save(state)
+	a3, err := newAmes(a.Ship, a.Life, [32]byte{}, [32]byte{}, nil, Options{Store: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a3.Close(context.Background())
+	introduce(a3, b)
+	err = a3.restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c3, err := a3.GetConnection(b.Ship, c.bone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c3.mut.Lock()
+	num = c3.num
+	c3.mut.Unlock()
+	if num != 3 {
+		t.Errorf("expected num 3 after a rekey got %v", num)
+	}
+
+	// a state for another rift, or any state when we breach, is ignored
+	for _, opts := range []Options{{Store: store, Rift: 1}, {Store: store, Breach: true}} {
+		store.Save(state)
+		a4, err := newAmes(a.Ship, a.Life, [32]byte{}, [32]byte{}, nil, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = a4.restore()
+		if err != nil || len(a4.peers()) != 0 {
+			t.Errorf("expected no peers got %v %v", a4.peers(), err)
+		}
+		a4.Close(context.Background())
+	}
+}
+
+// TestStoreRestoreOffline restores peers from their saved keys while
+// every lookup fails
+func TestStoreRestoreOffline(t *testing.T) {
+	store := NewFileStore(filepath.Join(t.TempDir(), "ames.json"))
+	ship, _ := noun.Patp2bn("~wicdev-wisryt")
+	id, err := GenerateIdentity(ship, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubKey := urcrypt.UrcryptEdPuck(id.CryptKey)
+	state := &State{
+		Ship: "~donryg-ribwyt",
+		Life: 1,
+		Peers: []PeerState{{
+			Ship:          "~wicdev-wisryt",
+			NextBone:      5,
+			Life:          1,
+			Rift:          2,
+			EncryptionKey: keyHex(pubKey),
+			Sponsor:       "~zod",
+			Flows:         []FlowState{{Bone: 1, Num: 4, LastAcked: 3}},
+		}, {
+			// saved before keys were kept, restored once looked up
+			Ship:     "~panret-tocsel",
+			NextBone: 5,
+			Flows:    []FlowState{{Bone: 1, Num: 7}},
+		}},
+	}
+	err = store.Save(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	us, _ := noun.Patp2bn("~donryg-ribwyt")
+	lookups := 0
+	a, err := newAmes(us, 1, [32]byte{}, [32]byte{}, nil, Options{
+		Store: store,
+		Lookup: func(name string) (LookupResponse, error) {
+			lookups++
+			return LookupResponse{}, errors.New("offline")
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close(context.Background())
+	err = a.restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookups != 0 || len(a.peers()) != 1 || len(a.restored) != 1 {
+		t.Fatalf("expected one peer and no lookups got %v %v", len(a.peers()), lookups)
+	}
+	c, err := a.GetConnection(ship, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := c.Peer
+	p.keyMut.RLock()
+	life, rift, key, resolved := p.life, p.rift, p.pubKey, p.resolved
+	p.keyMut.RUnlock()
+	if life != 1 || rift != 2 || key != pubKey || !resolved.IsZero() {
+		t.Errorf("expected life 1 rift 2 got %v %v %v", life, rift, resolved)
+	}
+	c.mut.Lock()
+	num, acked := c.num, c.sink.lastAcked
+	c.mut.Unlock()
+	if num != 4 || acked != 3 {
+		t.Errorf("expected %v %v got %v %v", 4, 3, num, acked)
+	}
+}
+
+func TestFileStoreJournal(t *testing.T) {
+	s := NewFileStore(filepath.Join(t.TempDir(), "ames.json"))
+	err := s.Save(&State{Ship: "~sampel-palnet", Life: 1, Peers: []PeerState{{
+		Ship:     "~zod",
+		NextBone: 5,
+		Flows:    []FlowState{{Bone: 1, Num: 2, LastAcked: 4}},
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []journalEntry{
+		{"~zod", FlowState{Bone: 1, Num: 3, Pending: []PendingMessage{{2, []byte{1}}}}},
+		{"~zod", FlowState{Bone: 1, LastAcked: 5}},
+		{"~zod", FlowState{Bone: 5, Num: 2, Pending: []PendingMessage{{1, []byte{2}}}}},
+		{"~nec", FlowState{Bone: 0, LastAcked: 1}},
+	} {
+		err = s.Append(e.Ship, e.Flow)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	r0, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &State{Ship: "~sampel-palnet", Life: 1, Peers: []PeerState{{
+		Ship:     "~zod",
+		NextBone: 9,
+		Flows: []FlowState{
+			{Bone: 1, Num: 3, LastAcked: 5, Pending: []PendingMessage{{2, []byte{1}}}},
+			{Bone: 5, Num: 2, Pending: []PendingMessage{{1, []byte{2}}}},
+		},
+	}, {
+		Ship:     "~nec",
+		NextBone: 1,
+		Flows:    []FlowState{{Bone: 0, LastAcked: 1}},
+	}}}
+	if !reflect.DeepEqual(r0, expected) {
+		t.Errorf("expected %v got %v", expected, r0)
+	}
+
+	// a save takes in the journal
+	err = s.Save(r0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Append("~zod", FlowState{Bone: 1, Num: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r1, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected.Peers[0].Flows[0].Num = 4
+	if !reflect.DeepEqual(r1, expected) {
+		t.Errorf("expected %v got %v", expected, r1)
+	}
+}
+
+// TestStoreAcked checks a delivered message is saved by the time its ack
+// is heard, with no tick in between
+func TestStoreAcked(t *testing.T) {
+	interval := pumpInterval
+	pumpInterval = time.Hour
+	defer func() { pumpInterval = interval }()
+	store := NewFileStore(filepath.Join(t.TempDir(), "ames.json"))
+	a, b := recordedPair(t, Options{Store: store})
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+	err := a.restore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := b.Connect(shipName(a.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = c.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Peers) != 1 || len(state.Peers[0].Flows) != 1 || state.Peers[0].Flows[0].LastAcked != 1 {
+		t.Errorf("expected last acked 1 got %v", state.Peers)
+	}
+}
+
+func BenchmarkSaveSent(b *testing.B) {
+	// a moon talking to a hundred ships on a few flows each
+	state := &State{Ship: "~sampel-palnet", Life: 1}
+	for i := 0; i < 100; i++ {
+		ps := PeerState{Ship: shipName(noun.B(int64(0x10000 + i))), NextBone: 13}
+		for bone := 1; bone < 13; bone += 4 {
+			ps.Flows = append(ps.Flows, FlowState{
+				Bone:    bone,
+				Num:     10,
+				Pending: []PendingMessage{{9, make([]byte, 256)}},
+			})
+		}
+		state.Peers = append(state.Peers, ps)
+	}
+	flow := FlowState{Bone: 1, Num: 11, Pending: []PendingMessage{{10, make([]byte, 256)}}}
+
+	b.Run("save", func(b *testing.B) {
+		s := NewFileStore(filepath.Join(b.TempDir(), "ames.json"))
+		for i := 0; i < b.N; i++ {
+			err := s.Save(state)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("append", func(b *testing.B) {
+		s := NewFileStore(filepath.Join(b.TempDir(), "ames.json"))
+		for i := 0; i < b.N; i++ {
+			err := s.Append("~zod", flow)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// failingStore is a FileStore whose journal can't be written
+type failingStore struct {
+	*FileStore
+}
+
+func (s failingStore) Append(ship string, flow FlowState) error {
+	return errors.New("disk full")
+}
+
+// sentCounter counts the datagrams we send
+type sentCounter struct {
+	sent atomic.Int64
+}
+
+func (r *sentCounter) Record(c Capture) {
+	if c.Out {
+		r.sent.Add(1)
+	}
+}
+
+// TestStoreJournalFails sends nothing when the message can't be
+// journalled, and reuses its num for the next message
+func TestStoreJournalFails(t *testing.T) {
+	store := failingStore{NewFileStore(filepath.Join(t.TempDir(), "ames.json"))}
+	sent := &sentCounter{}
+	a, b := testPairOptions(t, Options{Store: store, Recorder: sent})
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+
+	name, _ := noun.BN2patp(b.Ship)
+	c, err := a.Connect(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.PokeAsync([]string{"ge", "hood"}, "helm-hi", noun.MakeNoun("hi"))
+	if err == nil || err.Error() != "disk full" {
+		t.Errorf("expected %v got %v", "disk full", err)
+	}
+	c.mut.Lock()
+	num, pending, futures := c.num, c.pump.Pending(), len(c.futures)
+	c.mut.Unlock()
+	if num != 1 || pending != 0 || futures != 0 {
+		t.Errorf("expected num 1 with nothing queued got %v %v %v", num, pending, futures)
+	}
+	if n := sent.sent.Load(); n != 0 {
+		t.Errorf("expected %v got %v", 0, n)
+	}
+}
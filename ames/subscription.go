@@ -0,0 +1,207 @@
+package ames
+
+import (
+	"errors"
+	"sync"
+)
+
+var ErrKicked = errors.New("subscription kicked")
+var ErrWatchNack = errors.New("watch nacked")
+
+// ErrSlowConsumer ends a subscription whose Facts went unread for
+// maxQueuedFacts facts
+var ErrSlowConsumer = errors.New("subscription facts not read")
+
+// maxQueuedFacts is how many facts wait for Facts to be read before the
+// subscription is left
+const maxQueuedFacts = 4096
+
+// Subscription is a watch on a remote gall agent. Facts are streamed on
+// Facts, which is closed when the subscription ends. Facts are queued
+// so a slow reader never holds up the connection
+type Subscription struct {
+	App         string
+	Path        []string
+	Facts       chan Fact
+	conn        *Connection
+	mut         sync.Mutex
+	queue       []Fact        // heard and not yet on Facts
+	wake        chan struct{} // tells deliver the queue or done changed
+	quit        chan struct{}
+	quitOnce    sync.Once
+	num         int // message num of the current watch
+	resubscribe bool
+	done        bool
+	err         error
+}
+
+// Subscribe watches path on app. Each subscription gets its own flow
+// as gall keys subscriptions by bone
+func (c *Connection) Subscribe(app string, path []string) (*Subscription, error) {
+	conn := c.ames.openConnection(c.Peer)
+	sub := &Subscription{
+		App:   app,
+		Path:  path,
+		Facts: make(chan Fact, 64),
+		conn:  conn,
+		wake:  make(chan struct{}, 1),
+		quit:  make(chan struct{}),
+	}
+	// set before the watch is sent, its nack can beat us back
+	conn.mut.Lock()
+	conn.sub = sub
+	conn.mut.Unlock()
+
+	sub.mut.Lock()
+	defer sub.mut.Unlock()
+	num, err := conn.sendPlea(Watch{App: app, Path: path})
+	if err == nil && !c.ames.spawn(sub.deliver) {
+		err = ErrClosed
+	}
+	if err != nil {
+		conn.mut.Lock()
+		if conn.sub == sub {
+			conn.sub = nil
+		}
+		conn.mut.Unlock()
+		return nil, err
+	}
+	sub.num = num
+	return sub, nil
+}
+
+// Leave ends the subscription and closes Facts, dropping facts not yet
+// read. The flow is corked after the leave, as gall does
+func (s *Subscription) Leave() error {
+	s.quitOnce.Do(func() { close(s.quit) })
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.done {
+		return s.err
+	}
+	_, err := s.conn.sendPlea(Leave{App: s.App})
+	if err == nil {
+		_, err = s.conn.sendPlea(Cork{})
+	}
+	s.end(nil)
+	return err
+}
+
+// SetResubscribe chooses whether a kick sends a new watch instead of
+// ending the subscription
+func (s *Subscription) SetResubscribe(resubscribe bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.resubscribe = resubscribe
+}
+
+// Err returns why the subscription ended, nil if it was left
+func (s *Subscription) Err() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.err
+}
+
+// onAck handles the ack of a message on the flow, a nack of the watch
+// means the agent rejected it
+func (s *Subscription) onAck(num int, nack bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.done || num != s.num || !nack {
+		return
+	}
+	s.end(ErrWatchNack)
+}
+
+// onBoon handles a Fact or Kick. It runs on the read loop so it only
+// queues facts for deliver
+func (s *Subscription) onBoon(boon Boon) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.done {
+		return
+	}
+	switch t := boon.(type) {
+	case Fact:
+		if len(s.queue) >= maxQueuedFacts {
+			s.conn.sendPlea(Leave{App: s.App})
+			s.end(ErrSlowConsumer)
+			return
+		}
+		s.queue = append(s.queue, t)
+		s.notify()
+	case Kick:
+		if !s.resubscribe {
+			s.end(ErrKicked)
+			return
+		}
+		num, err := s.conn.sendPlea(Watch{App: s.App, Path: s.Path})
+		if err != nil {
+			s.end(err)
+			return
+		}
+		s.num = num
+	}
+}
+
+// end marks the subscription done, Facts is closed once the facts
+// queued before it are read. It must be called with mut held
+func (s *Subscription) end(err error) {
+	s.done = true
+	s.err = err
+	s.notify()
+}
+
+// closed ends the subscription with ErrClosed before Facts is closed
+func (s *Subscription) closed() {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if !s.done {
+		s.end(ErrClosed)
+	}
+}
+
+func (s *Subscription) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// deliver moves queued facts onto Facts until the subscription ends,
+// or straight away once it is left or we close
+func (s *Subscription) deliver() {
+	defer close(s.Facts)
+	stop := s.conn.ames.quit
+	for {
+		s.mut.Lock()
+		queue, done := s.queue, s.done
+		s.queue = nil
+		s.mut.Unlock()
+		if len(queue) == 0 {
+			if done {
+				return
+			}
+			select {
+			case <-s.wake:
+			case <-s.quit:
+				return
+			case <-stop:
+				s.closed()
+				return
+			}
+			continue
+		}
+		for _, f := range queue {
+			select {
+			case s.Facts <- f:
+			case <-s.quit:
+				return
+			case <-stop:
+				s.closed()
+				return
+			}
+		}
+	}
+}
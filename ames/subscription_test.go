@@ -0,0 +1,264 @@
+package ames
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+// heardPlea is a plea the publisher was sent, with the flow it came on
+type heardPlea struct {
+	c *Connection
+	p Plea
+}
+
+// subPair returns a subscriber and a publisher on a hub. Pleas sent to
+// the publisher are passed to serve and then on heard
+func subPair(t *testing.T, serve func(Plea) error) (*Ames, *Ames, chan heardPlea) {
+	hub := NewHub(HubOptions{})
+	heard := make(chan heardPlea, 16)
+	a, err := newAmes(noun.B(0x10100), 1, [32]byte{}, [32]byte{}, nil, Options{Transport: hub.Listen()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newAmes(noun.B(0x10200), 1, [32]byte{}, [32]byte{}, nil, Options{
+		Transport: hub.Listen(),
+		Handler: HandlerFunc(func(c *Connection, p Plea) error {
+			heard <- heardPlea{c, p}
+			if serve != nil {
+				return serve(p)
+			}
+			return nil
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	introduce(a, b)
+	introduce(b, a)
+	return a, b, heard
+}
+
+func nextPlea(t *testing.T, heard chan heardPlea) heardPlea {
+	select {
+	case h := <-heard:
+		return h
+	case <-time.After(10 * time.Second):
+		t.Fatal("no plea heard")
+	}
+	return heardPlea{}
+}
+
+// give sends a boon back on the flow the watch came on
+func give(t *testing.T, c *Connection, b Boon) {
+	msg, err := EncodeBoon(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = c.send(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nextFact(t *testing.T, sub *Subscription) (Fact, bool) {
+	select {
+	case f, ok := <-sub.Facts:
+		return f, ok
+	case <-time.After(10 * time.Second):
+		t.Fatal("no fact")
+	}
+	return Fact{}, false
+}
+
+func TestSubscribe(t *testing.T) {
+	a, b, heard := subPair(t, nil)
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+
+	c, err := a.Connect(shipName(b.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := c.Subscribe("chat", []string{"updates"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nextPlea(t, heard)
+	w, ok := h.p.(Watch)
+	if !ok || w.App != "chat" || len(w.Path) != 1 || w.Path[0] != "updates" {
+		t.Fatalf("expected %v got %v", Watch{App: "chat", Path: []string{"updates"}}, h.p)
+	}
+
+	for i := 0; i < 3; i++ {
+		give(t, h.c, Fact{Mark: "noun", Data: noun.MakeNoun(i)})
+	}
+	for i := 0; i < 3; i++ {
+		f, ok := nextFact(t, sub)
+		if !ok || f.Mark != "noun" || f.Data.String() != noun.MakeNoun(i).String() {
+			t.Errorf("expected fact %v got %v %v", i, f, ok)
+		}
+	}
+
+	// leaving sends %u and corks the flow
+	err = sub.Leave()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := nextPlea(t, heard).p; p != (Leave{App: "chat"}) {
+		t.Errorf("expected %v got %v", Leave{App: "chat"}, p)
+	}
+	if p := nextPlea(t, heard).p; p != (Cork{}) {
+		t.Errorf("expected %v got %v", Cork{}, p)
+	}
+	if _, ok := nextFact(t, sub); ok || sub.Err() != nil {
+		t.Errorf("expected facts closed got %v", sub.Err())
+	}
+}
+
+func TestSubscriptionKick(t *testing.T) {
+	a, b, heard := subPair(t, nil)
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+
+	c, err := a.Connect(shipName(b.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := c.Subscribe("chat", []string{"updates"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nextPlea(t, heard)
+	give(t, h.c, Fact{Mark: "noun", Data: noun.MakeNoun(1)})
+	give(t, h.c, Kick{})
+
+	// facts before the kick are still read
+	if f, ok := nextFact(t, sub); !ok || f.Data.String() != noun.MakeNoun(1).String() {
+		t.Errorf("expected %v got %v", noun.MakeNoun(1), f)
+	}
+	if _, ok := nextFact(t, sub); ok {
+		t.Errorf("expected facts to be closed")
+	}
+	if sub.Err() != ErrKicked {
+		t.Errorf("expected %v got %v", ErrKicked, sub.Err())
+	}
+}
+
+func TestSubscriptionResubscribe(t *testing.T) {
+	a, b, heard := subPair(t, nil)
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+
+	c, err := a.Connect(shipName(b.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := c.Subscribe("chat", []string{"updates"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.SetResubscribe(true)
+	h := nextPlea(t, heard)
+	give(t, h.c, Kick{})
+
+	// the kick is answered with a new watch on the same flow
+	h2 := nextPlea(t, heard)
+	if _, ok := h2.p.(Watch); !ok || h2.c != h.c {
+		t.Fatalf("expected a watch on the same flow got %v", h2.p)
+	}
+	give(t, h2.c, Fact{Mark: "noun", Data: noun.MakeNoun(2)})
+	if f, ok := nextFact(t, sub); !ok || f.Data.String() != noun.MakeNoun(2).String() {
+		t.Errorf("expected %v got %v", noun.MakeNoun(2), f)
+	}
+	if sub.Err() != nil {
+		t.Errorf("expected %v got %v", nil, sub.Err())
+	}
+	sub.Leave()
+}
+
+func TestSubscriptionWatchNack(t *testing.T) {
+	a, b, _ := subPair(t, func(p Plea) error {
+		return errors.New("no such path")
+	})
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+
+	c, err := a.Connect(shipName(b.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := c.Subscribe("chat", []string{"nowhere"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := nextFact(t, sub); ok {
+		t.Errorf("expected facts to be closed")
+	}
+	if sub.Err() != ErrWatchNack {
+		t.Errorf("expected %v got %v", ErrWatchNack, sub.Err())
+	}
+}
+
+// TestSubscriptionSlowReader checks facts nobody reads don't hold up
+// other flows with the peer
+func TestSubscriptionSlowReader(t *testing.T) {
+	a, b, heard := subPair(t, nil)
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+
+	c, err := a.Connect(shipName(b.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := c.Subscribe("chat", []string{"updates"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := nextPlea(t, heard)
+	n := 2 * cap(sub.Facts)
+	for i := 0; i < n; i++ {
+		give(t, h.c, Fact{Mark: "noun", Data: noun.MakeNoun(i)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = c.Poke(ctx, []string{"ge", "hood"}, "helm-hi", noun.MakeNoun("hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		f, ok := nextFact(t, sub)
+		if !ok || f.Data.String() != noun.MakeNoun(i).String() {
+			t.Fatalf("expected fact %v got %v %v", i, f, ok)
+		}
+	}
+	sub.Leave()
+}
+
+// TestSubscribeFails leaves no subscription on the flow when the watch
+// can't be sent
+func TestSubscribeFails(t *testing.T) {
+	store := failingStore{NewFileStore(filepath.Join(t.TempDir(), "ames.json"))}
+	a, b := testPairOptions(t, Options{Store: store})
+	defer a.Close(context.Background())
+	defer b.Close(context.Background())
+
+	c, err := a.Connect(shipName(b.Ship))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := c.Subscribe("chat", []string{"updates"})
+	if err == nil || sub != nil {
+		t.Errorf("expected an error got %v %v", sub, err)
+	}
+	for _, conn := range a.connections() {
+		if s := conn.subscription(); s != nil {
+			t.Errorf("expected no subscription on bone %v got %v", conn.bone, s)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package ames
+
+import (
+	"net"
+)
+
+// Transport carries packets between us and other ships. Lanes are UDP
+// addresses whatever the transport, as they go on the wire in relayed
+// packets. ReadFrom must return an error wrapping net.ErrClosed once
+// closed
+type Transport interface {
+	ReadFrom(b []byte) (int, *net.UDPAddr, error)
+	WriteTo(b []byte, addr *net.UDPAddr) (int, error)
+	Close() error
+	LocalAddr() *net.UDPAddr
+}
+
+// ListenUDP is the default transport, a UDP socket on addr. A nil addr
+// listens on a random port
+func ListenUDP(addr *net.UDPAddr) (Transport, error) {
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadBuffer(4096 * 16)
+	return udpTransport{conn}, nil
+}
+
+type udpTransport struct {
+	conn *net.UDPConn
+}
+
+func (u udpTransport) ReadFrom(b []byte) (int, *net.UDPAddr, error) {
+	return u.conn.ReadFromUDP(b)
+}
+
+func (u udpTransport) WriteTo(b []byte, addr *net.UDPAddr) (int, error) {
+	return u.conn.WriteToUDP(b, addr)
+}
+
+func (u udpTransport) Close() error {
+	return u.conn.Close()
+}
+
+func (u udpTransport) LocalAddr() *net.UDPAddr {
+	return u.conn.LocalAddr().(*net.UDPAddr)
+}
@@ -0,0 +1,205 @@
+// Command amescat pokes an agent on a ship over ames, for scripts and
+// cron jobs:
+//
+//	amescat --ship ~sampel-palnet --app hood --mark helm-hi --noun "'hi'"
+//	amescat --ship ~sampel-palnet --app my-app --mark json --json '{"a":1}'
+//	amescat --ship ~sampel-palnet --app chat --watch /updates
+//
+// The moon seed is read from --seed-file or the AMES_SEED environment
+// variable. Flows are saved in --state between runs, so the moon is
+// never breached. The poke is sent as a %m plea and amescat waits for
+// its ack. With --watch the agent's facts are printed until it kicks us.
+// Pokes sent to us while running and facts are printed to stdout.
+//
+// It exits 0 when acked, 1 on an error such as bad flags or a failed
+// connection, 2 when nacked, printing the tang to stderr, and 3 on
+// timeout. The timeout covers connecting as well.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/stevelacy/go-urbit/ames"
+	"github.com/stevelacy/go-urbit/noun"
+)
+
+const (
+	exitAck     = 0
+	exitError   = 1
+	exitNack    = 2
+	exitTimeout = 3
+)
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	seedFile := flag.String("seed-file", "", "file holding our moon seed, instead of $AMES_SEED")
+	ship := flag.String("ship", "", "ship to poke, such as ~sampel-palnet")
+	app := flag.String("app", "", "agent to poke")
+	mark := flag.String("mark", "", "mark of the poke")
+	nounArg := flag.String("noun", "", "poke data written in hoon, such as \"'hi'\" or [%a 1]")
+	jsonArg := flag.String("json", "", "poke data as JSON, sent as hoon's json noun")
+	watch := flag.String("watch", "", "path to subscribe to after any poke, printing facts until kicked")
+	timeout := flag.Duration("timeout", time.Minute, "how long to wait to connect and for the ack, or for facts with --watch")
+	state := flag.String("state", "", "file our flows are saved in, by default amescat/<our ship>.json in the user cache directory")
+	flag.Parse()
+
+	if *ship == "" || *app == "" || (*mark == "" && *watch == "") {
+		fmt.Fprintln(os.Stderr, "amescat: --ship, --app and one of --mark or --watch are needed")
+		flag.Usage()
+		return exitError
+	}
+	if *nounArg != "" && *jsonArg != "" {
+		fmt.Fprintln(os.Stderr, "amescat: only one of --noun and --json can be given")
+		return exitError
+	}
+	data, err := pokeData(*nounArg, *jsonArg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "amescat:", err)
+		return exitError
+	}
+	seed, err := readSeed(*seedFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "amescat:", err)
+		return exitError
+	}
+
+	id, err := ames.NewKeyfileIdentity(seed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "amescat:", err)
+		return exitError
+	}
+	store, err := openStore(*state, id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "amescat:", err)
+		return exitError
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	a, err := ames.NewAmesContext(ctx, id, printEvent, ames.Options{Store: store})
+	if err != nil {
+		return exitCode(err)
+	}
+	defer a.Close(context.Background())
+	c, err := a.Connect(*ship)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "amescat:", err)
+		return exitError
+	}
+	if *mark != "" {
+		err = c.Poke(ctx, []string{"ge", *app}, *mark, data)
+		if err != nil {
+			return exitCode(err)
+		}
+	}
+	if *watch != "" {
+		return watchFacts(ctx, c, *app, *watch)
+	}
+	return exitAck
+}
+
+// pokeData parses --noun or --json, the poke is ~ without either
+func pokeData(n, j string) (noun.Noun, error) {
+	switch {
+	case n != "":
+		return noun.Parse(n)
+	case j != "":
+		return noun.FromJSON([]byte(j))
+	}
+	return noun.MakeNoun(0), nil
+}
+
+func readSeed(path string) (string, error) {
+	if path == "" {
+		seed := os.Getenv("AMES_SEED")
+		if seed == "" {
+			return "", errors.New("no seed, set AMES_SEED or --seed-file")
+		}
+		return seed, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// openStore opens the state file, kept per ship in the cache directory
+// unless given
+func openStore(path string, id *ames.Identity) (*ames.FileStore, error) {
+	if path == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		name, err := noun.BN2patp(id.Ship)
+		if err != nil {
+			return nil, err
+		}
+		path = filepath.Join(dir, "amescat", strings.TrimPrefix(name, "~")+".json")
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return ames.NewFileStore(path), nil
+}
+
+func watchFacts(ctx context.Context, c *ames.Connection, app, path string) int {
+	sub, err := c.Subscribe(app, strings.Split(strings.Trim(path, "/"), "/"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "amescat:", err)
+		return exitError
+	}
+	for {
+		select {
+		case f, ok := <-sub.Facts:
+			if !ok {
+				if errors.Is(sub.Err(), ames.ErrKicked) {
+					return exitAck
+				}
+				return exitCode(sub.Err())
+			}
+			printEvent(c, f)
+		case <-ctx.Done():
+			sub.Leave()
+			return exitCode(ctx.Err())
+		}
+	}
+}
+
+func exitCode(err error) int {
+	var nerr *ames.NackError
+	switch {
+	case err == nil:
+		return exitAck
+	case errors.As(err, &nerr), errors.Is(err, ames.ErrPokeNack), errors.Is(err, ames.ErrWatchNack):
+		fmt.Fprintln(os.Stderr, "amescat: nacked:", err)
+		return exitNack
+	case errors.Is(err, context.DeadlineExceeded):
+		fmt.Fprintln(os.Stderr, "amescat: timed out")
+		return exitTimeout
+	}
+	fmt.Fprintln(os.Stderr, "amescat:", err)
+	return exitError
+}
+
+// printEvent prints pokes sent to us and facts on our subscription
+func printEvent(c *ames.Connection, ev ames.Event) {
+	switch ev := ev.(type) {
+	case ames.Poke:
+		fmt.Println("poke", ev.App, ev.Mark, ev.Data)
+	case ames.Fact:
+		fmt.Println("fact", ev.Mark, ev.Data)
+	}
+}
@@ -0,0 +1,173 @@
+// Command amesdump prints ames packets from a capture file or as they
+// arrive on a UDP port.
+//
+// Captures are written by an ames.CaptureWriter set as Options.Recorder.
+// Given our seed and the keys of our peers, shut packets are
+// decrypted and their bone, num, fragment, acks and messages shown:
+//
+//	amesdump -r ames.cap -seed $MOON_SEED -peer ~sampel-palnet
+//	amesdump -l :13337 -w ames.cap
+//
+// A peer's keys are looked up on azimuth, or given inline as
+// ~ship=<encryption key hex>:<life> to work offline.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/stevelacy/go-urbit/ames"
+)
+
+type peerFlags []string
+
+func (p *peerFlags) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *peerFlags) Set(s string) error {
+	*p = append(*p, s)
+	return nil
+}
+
+func main() {
+	var peers peerFlags
+	read := flag.String("r", "", "capture file to read, - for stdin")
+	listen := flag.String("l", "", "address to hear live packets on, such as :13337")
+	write := flag.String("w", "", "file to save live packets to")
+	seed := flag.String("seed", os.Getenv("AMES_SEED"), "our seed or keyfile, to decrypt with")
+	keyfile := flag.String("keyfile", "", "path of our keyfile, instead of -seed")
+	flag.Var(&peers, "peer", "a peer to decrypt packets with, ~ship or ~ship=<key hex>:<life>, repeatable")
+	flag.Parse()
+
+	d, err := dumper(*seed, *keyfile, peers)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "amesdump:", err)
+		os.Exit(2)
+	}
+
+	switch {
+	case *read != "" && *listen == "":
+		err = dumpFile(d, *read)
+	case *listen != "" && *read == "":
+		err = dumpLive(d, *listen, *write)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "amesdump:", err)
+		os.Exit(1)
+	}
+}
+
+func dumper(seed, keyfile string, peers []string) (*ames.Dumper, error) {
+	var id *ames.Identity
+	var err error
+	switch {
+	case keyfile != "":
+		id, err = ames.LoadKeyfile(keyfile)
+	case seed != "":
+		id, err = ames.NewKeyfileIdentity(seed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := ames.NewDumper(id)
+	for _, p := range peers {
+		ship, res, err := peerKeys(p)
+		if err != nil {
+			return nil, err
+		}
+		err = d.AddPeer(ship, res)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// peerKeys reads a -peer flag, looking the ship up if no keys are given
+func peerKeys(p string) (string, ames.LookupResponse, error) {
+	ship, keys, inline := strings.Cut(p, "=")
+	if !inline {
+		res, err := ames.Lookup(ship)
+		return ship, res, err
+	}
+	key, life, ok := strings.Cut(keys, ":")
+	if !ok {
+		return "", ames.LookupResponse{}, fmt.Errorf("peer %s: expected <key hex>:<life>", ship)
+	}
+	l, err := strconv.ParseInt(life, 10, 64)
+	if err != nil {
+		return "", ames.LookupResponse{}, fmt.Errorf("peer %s: %w", ship, err)
+	}
+	return ship, ames.LookupResponse{EncryptionKey: strings.TrimPrefix(key, "0x"), Life: l}, nil
+}
+
+func dumpFile(d *ames.Dumper, path string) error {
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	cr := ames.NewCaptureReader(r)
+	for {
+		c, err := cr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println(d.Dump(c))
+	}
+}
+
+func dumpLive(d *ames.Dumper, addr, write string) error {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var w *ames.CaptureWriter
+	if write != "" {
+		f, err := os.Create(write)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = ames.NewCaptureWriter(f)
+	}
+
+	buf := make([]byte, 8192)
+	for {
+		n, src, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		c := ames.Capture{Time: time.Now(), Lane: src, Data: append([]byte{}, buf[:n]...)}
+		if w != nil {
+			w.Record(c)
+			if err := w.Err(); err != nil {
+				return err
+			}
+		}
+		fmt.Println(d.Dump(c))
+	}
+}
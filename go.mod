@@ -1,5 +1,5 @@
 module github.com/stevelacy/go-urbit
 
-go 1.16
+go 1.21
 
 require github.com/twmb/murmur3 v1.1.5
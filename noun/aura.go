@@ -0,0 +1,83 @@
+package noun
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// uwAlphabet is the digits of @uw, six bits each
+const uwAlphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-~"
+
+// BN2uw prints an atom as @uw, 0w1.-~aBc
+func BN2uw(a *big.Int) string {
+	if a.Sign() == 0 {
+		return "0w0"
+	}
+	digits := []byte{}
+	b := B(0).Set(a)
+	for b.Sign() > 0 {
+		digits = append(digits, uwAlphabet[b.Uint64()&63])
+		b.Rsh(b, 6)
+	}
+	return "0w" + group(digits, 5)
+}
+
+// Uw2bn parses @uw, with or without dots
+func Uw2bn(s string) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "0w") {
+		return nil, fmt.Errorf("invalid @uw %s", s)
+	}
+	digits := strings.ReplaceAll(s[2:], ".", "")
+	if digits == "" {
+		return nil, fmt.Errorf("invalid @uw %s", s)
+	}
+	b := B(0)
+	for _, r := range digits {
+		i := strings.IndexRune(uwAlphabet, r)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid @uw %s", s)
+		}
+		b.Lsh(b, 6)
+		b.Or(b, B(int64(i)))
+	}
+	return b, nil
+}
+
+// BN2ux prints an atom as @ux, 0x1.abcd
+func BN2ux(a *big.Int) string {
+	hex := []byte(a.Text(16))
+	// group wants the least significant digit first
+	for i := 0; i < len(hex)/2; i++ {
+		j := len(hex) - i - 1
+		hex[i], hex[j] = hex[j], hex[i]
+	}
+	return "0x" + group(hex, 4)
+}
+
+// Ux2bn parses @ux, with or without dots
+func Ux2bn(s string) (*big.Int, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "0x") {
+		return nil, fmt.Errorf("invalid @ux %s", s)
+	}
+	b, ok := B(0).SetString(strings.ReplaceAll(s[2:], ".", ""), 16)
+	if !ok {
+		return nil, fmt.Errorf("invalid @ux %s", s)
+	}
+	return b, nil
+}
+
+// group reverses digits, least significant first, and dots them every n
+// from the right
+func group(digits []byte, n int) string {
+	var sb strings.Builder
+	for i := len(digits) - 1; i >= 0; i-- {
+		sb.WriteByte(digits[i])
+		if i > 0 && i%n == 0 {
+			sb.WriteByte('.')
+		}
+	}
+	return sb.String()
+}
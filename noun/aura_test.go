@@ -0,0 +1,63 @@
+package noun
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestUw(t *testing.T) {
+	cases := map[string]int64{
+		"0w0":        0,
+		"0w1":        1,
+		"0w~":        63,
+		"0w10":       64,
+		"0w1.00000":  1 << 30,
+		"0w40.00000": 1 << 38,
+	}
+	for s, n := range cases {
+		r1 := BN2uw(B(n))
+		if r1 != s {
+			t.Errorf("expected %s got %s", s, r1)
+		}
+		r2, err := Uw2bn(s)
+		if err != nil || r2.Int64() != n {
+			t.Errorf("expected %v got %v %v", n, r2, err)
+		}
+	}
+
+	// round trips a key sized atom
+	key, _ := big.NewInt(0).SetString("17eeded2ff2b7a0000102200001c68c1a67e000702807e01", 16)
+	r3, err := Uw2bn(BN2uw(key))
+	if err != nil || r3.Cmp(key) != 0 {
+		t.Errorf("expected %v got %v %v", key, r3, err)
+	}
+
+	_, err = Uw2bn("0w1!2")
+	if err == nil {
+		t.Errorf("expected an invalid digit to fail")
+	}
+}
+
+func TestUx(t *testing.T) {
+	cases := map[string]int64{
+		"0x0":         0,
+		"0xff":        0xff,
+		"0x1.0000":    0x10000,
+		"0x17.eede":   0x17eede,
+		"0xdead.beef": 0xdeadbeef,
+	}
+	for s, n := range cases {
+		r1 := BN2ux(B(n))
+		if r1 != s {
+			t.Errorf("expected %s got %s", s, r1)
+		}
+		r2, err := Ux2bn(s)
+		if err != nil || r2.Int64() != n {
+			t.Errorf("expected %v got %v %v", n, r2, err)
+		}
+	}
+	_, err := Ux2bn("17eede")
+	if err == nil {
+		t.Errorf("expected a missing 0x to fail")
+	}
+}
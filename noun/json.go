@@ -0,0 +1,159 @@
+package noun
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// FromJSON builds hoon's json noun from a JSON document, as a %json
+// poke carries it:
+//
+//	null     ~
+//	true     [%b %.y]
+//	1.5      [%n '1.5']
+//	"hi"     [%s 'hi']
+//	[1]      [%a ~[[%n '1']]]
+//	{"a":1}  [%o (map @t json)]
+func FromJSON(data []byte) (Noun, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	err := dec.Decode(&v)
+	if err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("json: more than one value")
+	}
+	return jsonNoun(v)
+}
+
+func jsonNoun(v interface{}) (Noun, error) {
+	switch v := v.(type) {
+	case nil:
+		return MakeNoun(0), nil
+	case bool:
+		// loobean, 0 is yes
+		flag := 1
+		if v {
+			flag = 0
+		}
+		return MakeNoun([]interface{}{"b", flag}), nil
+	case json.Number:
+		return MakeNoun([]interface{}{"n", v.String()}), nil
+	case string:
+		return MakeNoun([]interface{}{"s", v}), nil
+	case []interface{}:
+		var list Noun = MakeNoun(0)
+		for i := len(v) - 1; i >= 0; i-- {
+			n, err := jsonNoun(v[i])
+			if err != nil {
+				return nil, err
+			}
+			list = Cell{Head: n, Tail: list}
+		}
+		return Cell{Head: StringToCord("a"), Tail: list}, nil
+	case map[string]interface{}:
+		var m Noun = MakeNoun(0)
+		for k, val := range v {
+			n, err := jsonNoun(val)
+			if err != nil {
+				return nil, err
+			}
+			m = MapPut(m, StringToCord(k), n)
+		}
+		return Cell{Head: StringToCord("o"), Tail: m}, nil
+	}
+	return nil, fmt.Errorf("json: unexpected %T", v)
+}
+
+// MapPut is put:by, adding key and val to a hoon map. A map is a treap
+// of [n=[key val] l r] nodes, ordered by gor on keys and mor on
+// priority, ~ when empty
+func MapPut(a, key, val Noun) Noun {
+	if isNull(a) {
+		return mapNode(Cell{Head: key, Tail: val}, MakeNoun(0), MakeNoun(0))
+	}
+	n, l, r := Head(a), Head(Tail(a)), Tail(Tail(a))
+	k := Head(n)
+	if Equal(key, k) {
+		return mapNode(Cell{Head: key, Tail: val}, l, r)
+	}
+	if gor(key, k) {
+		d := MapPut(l, key, val)
+		if mor(k, Head(Head(d))) {
+			return mapNode(n, d, r)
+		}
+		return mapNode(Head(d), Head(Tail(d)), mapNode(n, Tail(Tail(d)), r))
+	}
+	d := MapPut(r, key, val)
+	if mor(k, Head(Head(d))) {
+		return mapNode(n, l, d)
+	}
+	return mapNode(Head(d), mapNode(n, l, Head(Tail(d))), Tail(Tail(d)))
+}
+
+func mapNode(n, l, r Noun) Noun {
+	return Cell{Head: n, Tail: Cell{Head: l, Tail: r}}
+}
+
+func isNull(n Noun) bool {
+	a, ok := n.(Atom)
+	return ok && a.Value.Sign() == 0
+}
+
+// Equal is true if a and b are the same noun
+func Equal(a, b Noun) bool {
+	switch a := a.(type) {
+	case Atom:
+		b, ok := b.(Atom)
+		return ok && a.Value.Cmp(b.Value) == 0
+	case Cell:
+		b, ok := b.(Cell)
+		return ok && Equal(a.Head, b.Head) && Equal(a.Tail, b.Tail)
+	}
+	return false
+}
+
+// dor is depth first order, atoms before cells
+func dor(a, b Noun) bool {
+	if Equal(a, b) {
+		return true
+	}
+	ac, aCell := a.(Cell)
+	bc, bCell := b.(Cell)
+	switch {
+	case aCell && !bCell:
+		return false
+	case !aCell && bCell:
+		return true
+	case aCell:
+		if Equal(ac.Head, bc.Head) {
+			return dor(ac.Tail, bc.Tail)
+		}
+		return dor(ac.Head, bc.Head)
+	}
+	return a.(Atom).Value.Cmp(b.(Atom).Value) < 0
+}
+
+// gor orders by mug, then dor
+func gor(a, b Noun) bool {
+	c, d := Mug(a), Mug(b)
+	if c == d {
+		return dor(a, b)
+	}
+	return c < d
+}
+
+// mor orders by double mug, then dor
+func mor(a, b Noun) bool {
+	c := Mug(Atom{Value: new(big.Int).SetUint64(uint64(Mug(a)))})
+	d := Mug(Atom{Value: new(big.Int).SetUint64(uint64(Mug(b)))})
+	if c == d {
+		return dor(a, b)
+	}
+	return c < d
+}
@@ -0,0 +1,76 @@
+package noun
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFromJSON(t *testing.T) {
+	cases := map[string]Noun{
+		`null`:        MakeNoun(0),
+		`true`:        MakeNoun([]interface{}{"b", 0}),
+		`false`:       MakeNoun([]interface{}{"b", 1}),
+		`1.50`:        MakeNoun([]interface{}{"n", "1.50"}),
+		`"hi"`:        MakeNoun([]interface{}{"s", "hi"}),
+		`[1, "a"]`:    MakeNoun([]interface{}{"a", []interface{}{"n", "1"}, []interface{}{"s", "a"}, 0}),
+		`{"a": null}`: MakeNoun([]interface{}{"o", []interface{}{"a", 0}, 0, 0}),
+	}
+	for s, expected := range cases {
+		r1, err := FromJSON([]byte(s))
+		if err != nil {
+			t.Errorf("%s: %v", s, err)
+			continue
+		}
+		if !Equal(r1, expected) {
+			t.Errorf("%s: expected %v got %v", s, expected, r1)
+		}
+	}
+	for _, s := range []string{``, `{`, `1 2`} {
+		if _, err := FromJSON([]byte(s)); err == nil {
+			t.Errorf("expected %q to fail", s)
+		}
+	}
+}
+
+// TestMapPut checks the treap keeps its order whatever the insertion
+// order
+func TestMapPut(t *testing.T) {
+	var a, b Noun = MakeNoun(0), MakeNoun(0)
+	for i := 0; i < 50; i++ {
+		a = MapPut(a, MakeNoun(fmt.Sprint(i)), MakeNoun(i))
+		b = MapPut(b, MakeNoun(fmt.Sprint(49-i)), MakeNoun(49-i))
+	}
+	if !Equal(a, b) {
+		t.Errorf("expected the same map whatever the order")
+	}
+	a = MapPut(a, MakeNoun("7"), MakeNoun(700))
+	count := 0
+	var walk func(n Noun)
+	walk = func(n Noun) {
+		if isNull(n) {
+			return
+		}
+		node, l, r := Head(n), Head(Tail(n)), Tail(Tail(n))
+		count++
+		if Equal(Head(node), MakeNoun("7")) && !Equal(Tail(node), MakeNoun(700)) {
+			t.Errorf("expected %v got %v", 700, Tail(node))
+		}
+		for _, child := range []Noun{l, r} {
+			if !isNull(child) && !mor(Head(node), Head(Head(child))) {
+				t.Errorf("expected %v above %v", Head(node), Head(Head(child)))
+			}
+		}
+		if !isNull(l) && !gor(Head(Head(l)), Head(node)) {
+			t.Errorf("expected %v left of %v", Head(Head(l)), Head(node))
+		}
+		if !isNull(r) && gor(Head(Head(r)), Head(node)) {
+			t.Errorf("expected %v right of %v", Head(Head(r)), Head(node))
+		}
+		walk(l)
+		walk(r)
+	}
+	walk(a)
+	if count != 50 {
+		t.Errorf("expected %v got %v", 50, count)
+	}
+}
@@ -1,6 +1,7 @@
 package noun
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 	"math/bits"
@@ -303,6 +304,75 @@ func Cue(b *big.Int) Noun {
 	return q1
 }
 
+// SafeCue is Cue for untrusted input, it fails rather than reading past
+// the end of b or following a pointer to nothing
+func SafeCue(b *big.Int) (Noun, error) {
+	if b.Sign() == 0 {
+		return MakeNoun(0), nil
+	}
+	nmap := make(cueNounMap)
+	_, n, err := safeCueIn(nmap, b, 0, int64(b.BitLen()))
+	return n, err
+}
+
+func safeCueIn(nmap cueNounMap, b *big.Int, index, end int64) (int64, Noun, error) {
+	if index+2 > end {
+		return 0, nil, errors.New("cue: out of bits")
+	}
+	if b.Bit(int(index)) == 0 {
+		i, a, err := safeRub(index+1, b, end)
+		if err != nil {
+			return 0, nil, err
+		}
+		nmap[index] = a
+		return i + 1, a, nil
+	}
+	if b.Bit(int(index+1)) == 0 {
+		i1, n1, err := safeCueIn(nmap, b, index+2, end)
+		if err != nil {
+			return 0, nil, err
+		}
+		i2, n2, err := safeCueIn(nmap, b, index+2+i1, end)
+		if err != nil {
+			return 0, nil, err
+		}
+		cell := Cell{Head: n1, Tail: n2}
+		nmap[index] = cell
+		return i1 + i2 + 2, cell, nil
+	}
+	i, a, err := safeRub(index+2, b, end)
+	if err != nil {
+		return 0, nil, err
+	}
+	n, ok := nmap[a.Value.Int64()]
+	if !a.Value.IsInt64() || !ok {
+		return 0, nil, errors.New("cue: bad pointer")
+	}
+	return i + 2, n, nil
+}
+
+// safeRub is Rub bounded by end
+func safeRub(index int64, b *big.Int, end int64) (int64, Atom, error) {
+	var c int64
+	for ; b.Bit(int(index+c)) == 0; c++ {
+		if index+c >= end {
+			return 0, Atom{}, errors.New("cue: out of bits")
+		}
+	}
+	if c == 0 {
+		return 1, Atom{Value: B(0)}, nil
+	}
+	d := index + c + 1
+	if c > 63 || d+c-1 > end {
+		return 0, Atom{}, errors.New("cue: out of bits")
+	}
+	e := Cut(d, c-1, b).Int64() + 1<<(c-1)
+	if e < 0 || d+c-1+e > end {
+		return 0, Atom{}, errors.New("cue: out of bits")
+	}
+	return c + c + e, Atom{Value: Cut(d+c-1, e, b)}, nil
+}
+
 // StringToCord returns Atom of type cord
 func StringToCord(str string) Atom {
 	a := LittleToBig([]byte(str))
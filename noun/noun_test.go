@@ -160,3 +160,28 @@ func ExampleMakeNoun() {
 	fmt.Println(stringNoun)
 	// Output: 31399942126277005645796504691
 }
+
+func TestSafeCue(t *testing.T) {
+	for _, n := range []Noun{
+		MakeNoun(0),
+		MakeNoun([]interface{}{12, 16}),
+		MakeNoun([]interface{}{[]interface{}{12, 16}, []interface{}{12, 16}}),
+		MakeNoun([]interface{}{"a long cord to make a long atom", 1, 0}),
+	} {
+		r1, err := SafeCue(Jam(n))
+		if err != nil || r1.String() != n.String() {
+			t.Errorf("expected %s got %v %v", n, r1, err)
+		}
+	}
+
+	// a run of zeros after a cell tag never ends
+	_, err := SafeCue(B(1))
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+	// a pointer past the end
+	_, err = SafeCue(B(0b1011))
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
@@ -0,0 +1,171 @@
+package noun
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Parse reads a noun written in hoon, as given to a poke in the dojo.
+// It knows cells and lists, cords, tapes, terms, loobeans, ~, @ud, @ux,
+// @uw and @p:
+//
+//	[%hi 'there' "tape" 1.000 0xff ~zod %.y ~[1 2 3]]
+func Parse(s string) (Noun, error) {
+	p := &parser{s: s}
+	n, err := p.noun()
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.i != len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.i:])
+	}
+	return n, nil
+}
+
+type parser struct {
+	s string
+	i int
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("parse: at %d: %s", p.i, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) space() {
+	for p.i < len(p.s) && strings.ContainsRune(" \t\n\r", rune(p.s[p.i])) {
+		p.i++
+	}
+}
+
+func (p *parser) peek(prefix string) bool {
+	return strings.HasPrefix(p.s[p.i:], prefix)
+}
+
+// word reads up to the next space or bracket
+func (p *parser) word() string {
+	start := p.i
+	for p.i < len(p.s) && !strings.ContainsRune(" \t\n\r[]", rune(p.s[p.i])) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+func (p *parser) noun() (Noun, error) {
+	p.space()
+	switch {
+	case p.i == len(p.s):
+		return nil, p.errorf("expected a noun")
+	case p.peek("~["):
+		p.i++
+		return p.cell(true)
+	case p.peek("["):
+		return p.cell(false)
+	case p.peek("'"):
+		s, err := p.quoted('\'')
+		if err != nil {
+			return nil, err
+		}
+		return StringToCord(s), nil
+	case p.peek("\""):
+		s, err := p.quoted('"')
+		if err != nil {
+			return nil, err
+		}
+		// a tape is a list of its bytes
+		var tape Noun = MakeNoun(0)
+		for i := len(s) - 1; i >= 0; i-- {
+			tape = Cell{Head: MakeNoun(int(s[i])), Tail: tape}
+		}
+		return tape, nil
+	}
+	return p.atom(p.word())
+}
+
+// cell reads [a b c] as [a [b c]], or ~[a b c] as [a b c ~]
+func (p *parser) cell(list bool) (Noun, error) {
+	p.i++
+	var items []interface{}
+	for {
+		p.space()
+		if p.peek("]") {
+			p.i++
+			break
+		}
+		n, err := p.noun()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, n)
+	}
+	if list {
+		items = append(items, 0)
+	}
+	if len(items) < 2 {
+		return nil, p.errorf("a cell needs two nouns")
+	}
+	return MakeNoun(items), nil
+}
+
+// quoted reads a string in quote with \\ and \q escapes
+func (p *parser) quoted(quote byte) (string, error) {
+	p.i++
+	var sb strings.Builder
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == quote:
+			return sb.String(), nil
+		case c == '\\' && p.i < len(p.s):
+			sb.WriteByte(p.s[p.i])
+			p.i++
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated %c", quote)
+}
+
+func (p *parser) atom(w string) (Noun, error) {
+	switch {
+	case w == "":
+		return nil, p.errorf("expected a noun")
+	case w == "~" || w == "%.y" || w == "&":
+		return MakeNoun(0), nil
+	case w == "%.n" || w == "|":
+		return MakeNoun(1), nil
+	case strings.HasPrefix(w, "%"):
+		return StringToCord(w[1:]), nil
+	case strings.HasPrefix(w, "~"):
+		// Patp2bn reads any syllables, so only a name that prints back
+		// the same is a @p
+		b, err := Patp2bn(w)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		if name, _ := BN2patp(b); name != w {
+			return nil, p.errorf("unknown atom %q", w)
+		}
+		return MakeNoun(b), nil
+	case strings.HasPrefix(w, "0x"):
+		b, err := Ux2bn(w)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		return MakeNoun(b), nil
+	case strings.HasPrefix(w, "0w"):
+		b, err := Uw2bn(w)
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		return MakeNoun(b), nil
+	}
+	// @ud, dotted every three digits
+	b, ok := new(big.Int).SetString(strings.ReplaceAll(w, ".", ""), 10)
+	if !ok || b.Sign() < 0 || strings.HasPrefix(w, ".") {
+		return nil, p.errorf("unknown atom %q", w)
+	}
+	return MakeNoun(b), nil
+}
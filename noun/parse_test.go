@@ -0,0 +1,42 @@
+package noun
+
+import (
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	zod, _ := Patp2bn("~nec")
+	cases := map[string]Noun{
+		"~":             MakeNoun(0),
+		"1.000":         MakeNoun(1000),
+		"0xff":          MakeNoun(255),
+		"0w10":          MakeNoun(64),
+		"~nec":          MakeNoun(zod),
+		"%.y":           MakeNoun(0),
+		"%.n":           MakeNoun(1),
+		"%hi":           MakeNoun("hi"),
+		"'it\\'s'":      MakeNoun("it's"),
+		"'[x y]'":       MakeNoun("[x y]"),
+		"\"ab\"":        MakeNoun([]interface{}{97, 98, 0}),
+		"[1 2 3]":       MakeNoun([]interface{}{1, 2, 3}),
+		"[[1 2] 3]":     MakeNoun([]interface{}{[]interface{}{1, 2}, 3}),
+		"~[1 2]":        MakeNoun([]interface{}{1, 2, 0}),
+		" [%a  'b'\n] ": MakeNoun([]interface{}{"a", "b"}),
+	}
+	for s, expected := range cases {
+		r1, err := Parse(s)
+		if err != nil {
+			t.Errorf("%q: %v", s, err)
+			continue
+		}
+		if !Equal(r1, expected) {
+			t.Errorf("%q: expected %v got %v", s, expected, r1)
+		}
+	}
+
+	for _, s := range []string{"", "[1]", "[1 2", "'open", "1 2", "-1", "~notapatp", "0xzz", "12x"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("expected %q to fail", s)
+		}
+	}
+}
@@ -74,7 +74,16 @@ func UrcryptAESSivcEn(message *big.Int, AESSivData [][]byte, key [64]byte) (erro
 }
 
 func UrcryptAESSivcDe(message *big.Int, AESSivData [][]byte, key [64]byte, iv [16]byte) (*big.Int, error) {
+	return UrcryptAESSivcDeLen(message, len(noun.BigToLittle(message)), AESSivData, key, iv)
+}
+
+// UrcryptAESSivcDeLen decrypts a message of length bytes. The atom
+// drops any trailing zero bytes of the cyphertext, which are restored
+func UrcryptAESSivcDeLen(message *big.Int, length int, AESSivData [][]byte, key [64]byte, iv [16]byte) (*big.Int, error) {
 	b := noun.BigToLittle(message)
+	if len(b) < length {
+		b = append(b, make([]byte, length-len(b))...)
+	}
 	message1 := (*C.uint8_t)(C.CBytes(b[:]))
 	msgLen := len(b)
 	msgLenU := (C.ulong)(msgLen)
@@ -113,3 +122,48 @@ func UrcryptAESSivcDe(message *big.Int, AESSivData [][]byte, key [64]byte, iv [1
 
 	return b3, nil
 }
+
+func UrcryptEdPuck(seed [32]byte) [32]byte {
+	out := C.malloc(32)
+	seed1 := (*C.uint8_t)(C.CBytes(seed[:]))
+
+	defer C.free(unsafe.Pointer(out))
+	defer C.free(unsafe.Pointer(seed1))
+
+	C.urcrypt_ed_puck(seed1, (*C.uint8_t)(out))
+
+	var out1 [32]byte
+	copy(out1[:], C.GoBytes(out, 32))
+
+	return out1
+}
+
+func UrcryptEdSign(message []byte, seed [32]byte) [64]byte {
+	out := C.malloc(64)
+	message1 := (*C.uint8_t)(C.CBytes(message))
+	seed1 := (*C.uint8_t)(C.CBytes(seed[:]))
+
+	defer C.free(unsafe.Pointer(out))
+	defer C.free(unsafe.Pointer(message1))
+	defer C.free(unsafe.Pointer(seed1))
+
+	C.urcrypt_ed_sign(message1, (C.size_t)(len(message)), seed1, (*C.uint8_t)(out))
+
+	var out1 [64]byte
+	copy(out1[:], C.GoBytes(out, 64))
+
+	return out1
+}
+
+// UrcryptEdVeri returns true if signature is valid for message and public
+func UrcryptEdVeri(message []byte, signature [64]byte, public [32]byte) bool {
+	message1 := (*C.uint8_t)(C.CBytes(message))
+	signature1 := (*C.uint8_t)(C.CBytes(signature[:]))
+	public1 := (*C.uint8_t)(C.CBytes(public[:]))
+
+	defer C.free(unsafe.Pointer(message1))
+	defer C.free(unsafe.Pointer(signature1))
+	defer C.free(unsafe.Pointer(public1))
+
+	return bool(C.urcrypt_ed_veri(message1, (C.size_t)(len(message)), signature1, public1))
+}
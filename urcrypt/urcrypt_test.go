@@ -56,3 +56,40 @@ func TestUrcryptAESSivcDe(t *testing.T) {
 	}
 
 }
+
+func TestUrcryptEdSign(t *testing.T) {
+	seed := [32]byte{7}
+	msg := []byte("signed message")
+	pub := UrcryptEdPuck(seed)
+
+	sig := UrcryptEdSign(msg, seed)
+	if !UrcryptEdVeri(msg, sig, pub) {
+		t.Errorf("expected %v got %v", true, false)
+	}
+	sig[0] ^= 1
+	if UrcryptEdVeri(msg, sig, pub) {
+		t.Errorf("expected %v got %v", false, true)
+	}
+}
+
+// TestUrcryptAESSivcDeLen decrypts a cyphertext ending in a zero byte,
+// which its atom drops
+func TestUrcryptAESSivcDeLen(t *testing.T) {
+	siv := [][]byte{{2}}
+	for i := int64(256); i < 1<<16; i++ {
+		msg := big.NewInt(i)
+		_, iv, ct := UrcryptAESSivcEn(msg, siv, [64]byte{4})
+		if ct.BitLen() > 8 {
+			continue
+		}
+		r1, err := UrcryptAESSivcDeLen(ct, 2, siv, [64]byte{4}, iv)
+		if err != nil || r1.Cmp(msg) != 0 {
+			t.Errorf("expected %d got %d %v", msg, r1, err)
+		}
+		if _, err := UrcryptAESSivcDe(ct, siv, [64]byte{4}, iv); err == nil {
+			t.Errorf("expected the short cyphertext to fail")
+		}
+		return
+	}
+	t.Fatal("expected a cyphertext with a zero last byte")
+}